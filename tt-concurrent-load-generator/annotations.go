@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// annotations is the ground-truth log of the running experiment, nil if none
+var annotations *Annotations
+
+// Annotation events
+const (
+	AnnotationRunStart    = "run_start"
+	AnnotationRunStop     = "run_stop"
+	AnnotationPhase       = "phase"
+	AnnotationBurstSet    = "burst_set"
+	AnnotationBurstAck    = "burst_ack"
+	AnnotationBurstUnack  = "burst_unacknowledged"
+	AnnotationBurstFailed = "burst_failed"
+)
+
+// Annotation is one line of the annotations file. Time is the wall clock
+// for joining with traces, OffsetMs the monotonic time since the run started,
+// which stays exact if the wall clock is adjusted during the run.
+type Annotation struct {
+	Time     time.Time `json:"time"`
+	UnixNano int64     `json:"unix_nano"`
+	OffsetMs float64   `json:"offset_ms"`
+	Event    string    `json:"event"`
+
+	Phase   string       `json:"phase,omitempty"`
+	Service string       `json:"service,omitempty"`
+	Params  *BurstParams `json:"params,omitempty"`
+	// Applied and Acknowledged report, after a change, whether the service
+	// accepted it and whether reading the parameters back confirmed it
+	Applied      *bool `json:"applied,omitempty"`
+	Acknowledged *bool `json:"acknowledged,omitempty"`
+	// Observed holds the parameters the service reported back after a change
+	Observed *BurstParams `json:"observed,omitempty"`
+	Reason   string       `json:"reason,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	// Info carries the run settings of run_start and the outcome of run_stop
+	Info map[string]interface{} `json:"info,omitempty"`
+}
+
+// Annotations writes Annotation entries as NDJSON, one line per entry and
+// straight to the file, so an aborted run keeps everything up to the abort
+type Annotations struct {
+	mu    sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+// NewAnnotations creates the annotations file and records the run start
+func NewAnnotations(path string, info map[string]interface{}) (*Annotations, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create annotations file: %v", err)
+	}
+
+	a := &Annotations{file: f, enc: json.NewEncoder(f), start: time.Now()}
+	a.Record(Annotation{Event: AnnotationRunStart, Info: info})
+	return a, nil
+}
+
+// Record stamps the entry with the current time and writes it
+func (a *Annotations) Record(entry Annotation) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	entry.Time = now.UTC()
+	entry.UnixNano = now.UnixNano()
+	entry.OffsetMs = ms(now.Sub(a.start))
+	if err := a.enc.Encode(entry); err != nil {
+		log.Printf("Failed to write annotation: %v", err)
+	}
+}
+
+// Phase records that the run entered the named phase
+func (a *Annotations) Phase(name string) {
+	a.Record(Annotation{Event: AnnotationPhase, Phase: name})
+}
+
+// Close records the run stop with its outcome and closes the file
+func (a *Annotations) Close(outcome string) error {
+	if a == nil {
+		return nil
+	}
+
+	a.Record(Annotation{Event: AnnotationRunStop, Info: map[string]interface{}{"outcome": outcome}})
+	return a.file.Close()
+}
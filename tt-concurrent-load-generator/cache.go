@@ -96,6 +96,7 @@ func UpdateOrderCache(q *Query) {
 					"from":        orderMap["from"],
 					"to":          orderMap["to"],
 					"trainNumber": orderMap["trainNumber"],
+					"travelDate":  orderMap["travelDate"],
 					"status":      orderMap["status"],
 				}
 
@@ -119,6 +120,7 @@ func UpdateOrderCache(q *Query) {
 					"from":        orderMap["from"],
 					"to":          orderMap["to"],
 					"trainNumber": orderMap["trainNumber"],
+					"travelDate":  orderMap["travelDate"],
 					"status":      orderMap["status"],
 				}
 
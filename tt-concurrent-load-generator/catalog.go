@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// StationPair is one origin/destination entry of the catalog. HighSpeed
+// selects the service family (travel vs travel2) the pair is served by.
+type StationPair struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Weight    int    `json:"weight"`
+	HighSpeed bool   `json:"highSpeed"`
+}
+
+// StationCatalog holds the weighted station pairs scenarios and warmup sample from
+type StationCatalog struct {
+	Pairs []StationPair `json:"pairs"`
+	// Names maps station IDs to the names the travel services expect
+	Names map[string]string `json:"names,omitempty"`
+}
+
+var Catalog *StationCatalog
+
+// DefaultCatalog returns the place pairs the generator has always used
+func DefaultCatalog() *StationCatalog {
+	return &StationCatalog{
+		Pairs: []StationPair{
+			{From: "Shang Hai", To: "Su Zhou", Weight: 1, HighSpeed: true},
+			{From: "Shang Hai", To: "Nan Jing", Weight: 1, HighSpeed: false},
+		},
+	}
+}
+
+// LoadCatalog reads a catalog from a JSON file of the form
+// {"pairs": [{"from": "Shang Hai", "to": "Su Zhou", "weight": 3, "highSpeed": true}]}
+func LoadCatalog(path string) (*StationCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %v", err)
+	}
+
+	var c StationCatalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %v", err)
+	}
+
+	for i := range c.Pairs {
+		if c.Pairs[i].From == "" || c.Pairs[i].To == "" {
+			return nil, fmt.Errorf("catalog entry %d is missing from/to", i)
+		}
+		if c.Pairs[i].Weight <= 0 {
+			c.Pairs[i].Weight = 1
+		}
+	}
+
+	if len(c.Pairs) == 0 {
+		return nil, fmt.Errorf("catalog %s has no pairs", path)
+	}
+
+	return &c, nil
+}
+
+// DiscoverCatalog builds a catalog from the routes, stations and trips of the
+// running system. Every ordered station pair on a route served by a trip
+// becomes an entry, weighted by the number of trips serving it.
+func DiscoverCatalog(q *Query) (*StationCatalog, error) {
+	routes, err := q.QueryRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	stations, err := q.QueryStations()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &StationCatalog{Names: make(map[string]string, len(stations))}
+	for _, station := range stations {
+		c.Names[station.ID] = station.Name
+	}
+
+	routesByID := make(map[string]Route, len(routes))
+	for _, route := range routes {
+		routesByID[route.ID] = route
+	}
+
+	weights := make(map[StationPair]int)
+	for _, highSpeed := range []bool{true, false} {
+		trips, err := q.QueryTrips(highSpeed)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trip := range trips {
+			route, ok := routesByID[trip.RouteID]
+			if !ok {
+				continue
+			}
+			for i := 0; i < len(route.Stations); i++ {
+				for j := i + 1; j < len(route.Stations); j++ {
+					pair := StationPair{
+						From:      c.StationName(route.Stations[i]),
+						To:        c.StationName(route.Stations[j]),
+						HighSpeed: highSpeed,
+					}
+					weights[pair]++
+				}
+			}
+		}
+	}
+
+	for pair, weight := range weights {
+		pair.Weight = weight
+		c.Pairs = append(c.Pairs, pair)
+	}
+
+	if len(c.Pairs) == 0 {
+		return nil, fmt.Errorf("no station pairs discovered")
+	}
+
+	// Keep the order stable so seeded runs sample the same pairs
+	sort.Slice(c.Pairs, func(i, j int) bool {
+		a, b := c.Pairs[i], c.Pairs[j]
+		if a.HighSpeed != b.HighSpeed {
+			return a.HighSpeed
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+
+	log.Printf("Discovered %d station pairs from %d routes and %d stations", len(c.Pairs), len(routes), len(stations))
+	return c, nil
+}
+
+// InitCatalog sets Catalog from a catalog file, "discover" or "" for the defaults
+func InitCatalog(source string, url string) error {
+	switch source {
+	case "":
+		Catalog = DefaultCatalog()
+	case "discover":
+		q := NewQuery(url)
+		if err := q.Login("fdse_microservice", "111111"); err != nil {
+			return fmt.Errorf("login for catalog discovery failed: %v", err)
+		}
+		c, err := DiscoverCatalog(q)
+		if err != nil {
+			return err
+		}
+		Catalog = c
+	default:
+		c, err := LoadCatalog(source)
+		if err != nil {
+			return err
+		}
+		Catalog = c
+	}
+
+	return nil
+}
+
+// StationName maps a station ID to its name, leaving unknown values untouched
+func (c *StationCatalog) StationName(id string) string {
+	if name, ok := c.Names[id]; ok {
+		return name
+	}
+	return id
+}
+
+// Sample picks a weighted station pair of the requested service family. If the
+// catalog has no pair for that family it falls back to the default pairs.
+func (c *StationCatalog) Sample(r *rand.Rand, highSpeed bool) [2]string {
+	total := 0
+	for _, pair := range c.Pairs {
+		if pair.HighSpeed == highSpeed {
+			total += pair.Weight
+		}
+	}
+
+	if total == 0 {
+		// The default catalog serves both families, so this cannot recurse further
+		return DefaultCatalog().Sample(r, highSpeed)
+	}
+
+	n := r.Intn(total)
+	for _, pair := range c.Pairs {
+		if pair.HighSpeed != highSpeed {
+			continue
+		}
+		n -= pair.Weight
+		if n < 0 {
+			return [2]string{pair.From, pair.To}
+		}
+	}
+
+	return [2]string{c.Pairs[0].From, c.Pairs[0].To}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CleanupFilter selects the orders cleanup acts on
+type CleanupFilter struct {
+	// From and To bound the travel day, inclusive; empty means unbounded
+	From string
+	To   string
+	// Statuses holds the order statuses to act on, nil for all
+	Statuses map[int]bool
+}
+
+// ParseStatuses parses a comma separated list of status names (NOTPAID,
+// PAID, ...) or numbers
+func ParseStatuses(spec string) (map[int]bool, error) {
+	statuses := make(map[int]bool)
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.ToUpper(strings.TrimSpace(token))
+		if token == "" {
+			continue
+		}
+
+		status, err := strconv.Atoi(token)
+		if err != nil {
+			status = -1
+			for i, name := range orderStatusNames {
+				if name == token {
+					status = i
+				}
+			}
+		}
+		if status < 0 || status >= len(orderStatusNames) {
+			return nil, fmt.Errorf("unknown order status %q (known: %s)", token, strings.Join(orderStatusNames, ", "))
+		}
+		statuses[status] = true
+	}
+
+	return statuses, nil
+}
+
+// NewCleanupFilter validates the travel day range and parses the statuses
+func NewCleanupFilter(from, to, statuses string) (CleanupFilter, error) {
+	f := CleanupFilter{From: from, To: to}
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return f, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", day)
+		}
+	}
+
+	if statuses != "" {
+		var err error
+		if f.Statuses, err = ParseStatuses(statuses); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+func (f CleanupFilter) Match(o Order) bool {
+	if f.Statuses != nil && !f.Statuses[o.Status] {
+		return false
+	}
+	day := o.Day()
+	if f.From != "" && day < f.From {
+		return false
+	}
+	if f.To != "" && day > f.To {
+		return false
+	}
+	return true
+}
+
+// CleanupResult counts what a cleanup did
+type CleanupResult struct {
+	Matched   int
+	Cancelled int
+	Deleted   int
+	Failed    int
+}
+
+// RunCleanup cancels the unpaid and paid orders of the account of q that
+// match the filter and, with deleteOrders, deletes every matching order
+// through the admin order service. A dry run only lists what it would do.
+// Consignments have no delete endpoint and are left alone.
+func RunCleanup(q *Query, filter CleanupFilter, deleteOrders, dryRun bool) (CleanupResult, error) {
+	var result CleanupResult
+
+	for _, highSpeed := range []bool{true, false} {
+		orders, err := q.RefreshOrders(!highSpeed)
+		if err != nil {
+			return result, err
+		}
+
+		for _, order := range orders {
+			if !filter.Match(order) {
+				continue
+			}
+			result.Matched++
+
+			cancel := order.Status == OrderNotPaid || order.Status == OrderPaid
+			if dryRun {
+				actions := []string{}
+				if cancel {
+					actions = append(actions, "cancel")
+				}
+				if deleteOrders {
+					actions = append(actions, "delete")
+				}
+				if len(actions) == 0 {
+					actions = append(actions, "keep")
+				}
+				log.Printf("[dry run] %s order %s of trip %s on %s (%s -> %s): %s",
+					OrderStatusName(order.Status), order.ID, order.TrainNumber, order.Day(),
+					order.From, order.To, strings.Join(actions, ", "))
+				continue
+			}
+
+			if cancel {
+				if err := q.CancelOrder(order.ID, q.UID); err != nil {
+					log.Printf("Error cancelling order %s: %v", order.ID, err)
+					result.Failed++
+					continue
+				}
+				result.Cancelled++
+			}
+
+			if deleteOrders {
+				if err := q.AdminDeleteOrder(order.ID, order.TrainNumber); err != nil {
+					log.Printf("Error deleting order %s: %v", order.ID, err)
+					result.Failed++
+					continue
+				}
+				result.Deleted++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// runCleanup runs a cleanup against url and logs its result
+func runCleanup(url string, filter CleanupFilter, deleteOrders, dryRun bool) {
+	result, err := RunCleanup(login(url), filter, deleteOrders, dryRun)
+	if err != nil {
+		log.Fatalf("Cleanup failed: %v", err)
+	}
+
+	if dryRun {
+		log.Printf("Dry run: %d orders match", result.Matched)
+		return
+	}
+	log.Printf("Cleanup completed: %d orders matched, %d cancelled, %d deleted, %d failures",
+		result.Matched, result.Cancelled, result.Deleted, result.Failed)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServiceEndpoints are the control endpoints of a bursty service, as paths
+// below the gateway address
+type ServiceEndpoints struct {
+	Set string `yaml:"set"`
+	Get string `yaml:"get"`
+}
+
+// UnmarshalYAML accepts a base path such as "/api/v1/seatservice", below
+// which the endpoints are setBurstParams and getBurstParams, as well as the
+// expanded form with set and get
+func (e *ServiceEndpoints) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*e = serviceEndpoints(node.Value)
+		return nil
+	}
+
+	type plain ServiceEndpoints
+	return node.Decode((*plain)(e))
+}
+
+func serviceEndpoints(base string) ServiceEndpoints {
+	base = strings.TrimSuffix(base, "/")
+	return ServiceEndpoints{Set: base + "/setBurstParams", Get: base + "/getBurstParams"}
+}
+
+// ServiceRegistry maps service names to their control endpoints
+type ServiceRegistry map[string]ServiceEndpoints
+
+// Services is the registry the control client resolves names with
+var Services = DefaultServiceRegistry()
+
+// DefaultServiceRegistry lists the services of the bursty Train-Ticket build
+func DefaultServiceRegistry() ServiceRegistry {
+	return ServiceRegistry{
+		"ts-basic-service":    serviceEndpoints("/api/v1/basicservice"),
+		"ts-cancel-service":   serviceEndpoints("/api/v1/cancelservice"),
+		"ts-seat-service":     serviceEndpoints("/api/v1/seatservice"),
+		"ts-travel-service":   serviceEndpoints("/api/v1/travelservice"),
+		"ts-preserve-service": serviceEndpoints("/api/v1/preserveservice"),
+	}
+}
+
+// LoadServiceRegistry reads a registry from a YAML or JSON file of the form
+// {services: {ts-seat-service: /api/v1/seatservice}}
+func LoadServiceRegistry(path string) (ServiceRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service registry: %v", err)
+	}
+
+	var file struct {
+		Services ServiceRegistry `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse service registry: %v", err)
+	}
+
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("service registry %s defines no services", path)
+	}
+	for name, endpoints := range file.Services {
+		if endpoints.Set == "" || endpoints.Get == "" {
+			return nil, fmt.Errorf("service %s needs both a set and a get endpoint", name)
+		}
+	}
+
+	return file.Services, nil
+}
+
+// Names returns the registered services in order
+func (r ServiceRegistry) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the endpoints of a service, unknown services are an error
+func (r ServiceRegistry) Lookup(service string) (ServiceEndpoints, error) {
+	endpoints, ok := r[service]
+	if !ok {
+		return ServiceEndpoints{}, fmt.Errorf("unknown service %q (known: %s)", service, strings.Join(r.Names(), ", "))
+	}
+	return endpoints, nil
+}
+
+// BurstParams configure the bursts a service injects: every Period seconds
+// it sends Rate requests per second for Duration seconds
+type BurstParams struct {
+	Period   int `json:"period"`
+	Rate     int `json:"rate"`
+	Duration int `json:"duration"`
+}
+
+// MarshalJSON encodes the [period, rate, duration] array setBurstParams takes
+func (p BurstParams) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]int{p.Period, p.Rate, p.Duration})
+}
+
+// UnmarshalJSON accepts the array form as well as an object with period,
+// rate and duration
+func (p *BurstParams) UnmarshalJSON(b []byte) error {
+	var values []int
+	if err := json.Unmarshal(b, &values); err == nil {
+		if len(values) != 3 {
+			return fmt.Errorf("expected [period, rate, duration], got %d values", len(values))
+		}
+		*p = BurstParams{Period: values[0], Rate: values[1], Duration: values[2]}
+		return nil
+	}
+
+	type plain BurstParams
+	return json.Unmarshal(b, (*plain)(p))
+}
+
+// Disabled reports whether the parameters turn bursts off
+func (p BurstParams) Disabled() bool {
+	return p.Rate == 0 || p.Duration == 0
+}
+
+func (p BurstParams) String() string {
+	if p.Disabled() {
+		return fmt.Sprintf("off (period %ds, rate %d/s, duration %ds)", p.Period, p.Rate, p.Duration)
+	}
+	return fmt.Sprintf("every %ds, %d req/s for %ds", p.Period, p.Rate, p.Duration)
+}
+
+// ControlClient sets and reads burst parameters through the gateway
+type ControlClient struct {
+	q        *Query
+	registry ServiceRegistry
+}
+
+// NewControlClient logs in at url and resolves services with registry
+func NewControlClient(url string, registry ServiceRegistry) (*ControlClient, error) {
+	q := NewQuery(url)
+	if err := q.Login("fdse_microservice", "111111"); err != nil {
+		return nil, fmt.Errorf("login failed: %v", err)
+	}
+	return &ControlClient{q: q, registry: registry}, nil
+}
+
+// do sends a control request and returns the response body after checking
+// the HTTP status and, if the body is a response envelope, its status
+func (c *ControlClient) do(method, path string, payload interface{}) ([]byte, error) {
+	if err := c.q.CheckAndRefreshToken(); err != nil {
+		return nil, err
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		jsonPayload, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %v", err)
+		}
+		reqBody = bytes.NewBuffer(jsonPayload)
+	}
+
+	req, err := http.NewRequest(method, c.q.Address+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.q.Token)
+
+	resp, err := c.q.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var envelope struct {
+		Status *int   `json:"status"`
+		Msg    string `json:"msg"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Status != nil && *envelope.Status != 1 {
+		return nil, fmt.Errorf("service rejected the request: %s", envelope.Msg)
+	}
+
+	return body, nil
+}
+
+// SetBurstParams sets the burst parameters of a service
+func (c *ControlClient) SetBurstParams(service string, p BurstParams) error {
+	endpoints, err := c.registry.Lookup(service)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.do("POST", endpoints.Set, p); err != nil {
+		return fmt.Errorf("set burst parameters of %s failed: %v", service, err)
+	}
+	return nil
+}
+
+// GetBurstParams reads the burst parameters of a service
+func (c *ControlClient) GetBurstParams(service string) (BurstParams, error) {
+	endpoints, err := c.registry.Lookup(service)
+	if err != nil {
+		return BurstParams{}, err
+	}
+
+	body, err := c.do("GET", endpoints.Get, nil)
+	if err != nil {
+		return BurstParams{}, fmt.Errorf("get burst parameters of %s failed: %v", service, err)
+	}
+
+	// The parameters come bare or in the data field of an envelope
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && len(envelope.Data) > 0 {
+		body = envelope.Data
+	}
+
+	var p BurstParams
+	if err := json.Unmarshal(body, &p); err != nil {
+		return BurstParams{}, fmt.Errorf("unexpected burst parameters of %s: %s", service, string(body))
+	}
+	return p, nil
+}
+
+// FormatBurstParams reads the parameters of every registered service into a
+// table
+func (c *ControlClient) FormatBurstParams() string {
+	result := fmt.Sprintf("  %-22s %8s %6s %9s  %s\n", "Service", "Period", "Rate", "Duration", "")
+	for _, service := range c.registry.Names() {
+		p, err := c.GetBurstParams(service)
+		if err != nil {
+			result += fmt.Sprintf("  %-22s error: %v\n", service, err)
+			continue
+		}
+		result += fmt.Sprintf("  %-22s %8d %6d %9d  %s\n", service, p.Period, p.Rate, p.Duration, p)
+	}
+	return result
+}
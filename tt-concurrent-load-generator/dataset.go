@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Dataset is a snapshot of what the generator account stores: its contacts,
+// its orders in both order services and their consignments
+type Dataset struct {
+	ExportedAt string         `json:"exportedAt"`
+	Address    string         `json:"address"`
+	Contacts   []Contact      `json:"contacts"`
+	Orders     []DatasetOrder `json:"orders"`
+	Consigns   []Consign      `json:"consigns"`
+}
+
+// DatasetOrder is an order and the service family that stores it
+type DatasetOrder struct {
+	Order
+	HighSpeed bool `json:"highSpeed"`
+}
+
+// ExportDataset reads the contacts, orders and consignments of the account
+// of q
+func ExportDataset(q *Query) (*Dataset, error) {
+	d := &Dataset{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Address:    q.Address,
+	}
+
+	var err error
+	if d.Contacts, err = q.QueryContacts(); err != nil {
+		return nil, err
+	}
+
+	for _, highSpeed := range []bool{true, false} {
+		orders, err := q.RefreshOrders(!highSpeed)
+		if err != nil {
+			return nil, err
+		}
+		for _, order := range orders {
+			d.Orders = append(d.Orders, DatasetOrder{Order: order, HighSpeed: highSpeed})
+		}
+	}
+
+	if d.Consigns, err = q.QueryConsignsByAccount(q.UID); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// ImportResult counts what an import recreated
+type ImportResult struct {
+	Contacts int
+	Orders   int
+	Consigns int
+	Skipped  int
+	Failed   int
+}
+
+// ImportDataset recreates an equivalent dataset for the account of q with
+// the fewest calls: missing contacts are added, every order is booked for
+// its contact, trip, seat class and day and then pushed to its status, and
+// consignments are attached to the recreated orders. IDs differ from the
+// snapshot, and orders in statuses the API cannot reach (CHANGE, REFUNDS)
+// are skipped.
+func ImportDataset(q *Query, d *Dataset) (ImportResult, error) {
+	var result ImportResult
+
+	existing, err := q.QueryContacts()
+	if err != nil {
+		return result, err
+	}
+
+	// Contacts are matched by document, the order only records the document
+	contactIDs := make(map[string]string)
+	contactKey := func(docType int, docNumber string) string {
+		return strconv.Itoa(docType) + "/" + docNumber
+	}
+	for _, c := range existing {
+		contactIDs[contactKey(c.DocumentType, c.DocumentNumber)] = c.ID
+	}
+	for _, c := range d.Contacts {
+		key := contactKey(c.DocumentType, c.DocumentNumber)
+		if _, ok := contactIDs[key]; ok {
+			continue
+		}
+		c.ID, c.AccountID = "", q.UID
+		created, err := q.AddContact(c)
+		if err != nil {
+			log.Printf("Error adding contact %s: %v", c.Name, err)
+			result.Failed++
+			continue
+		}
+		contactIDs[key] = created.ID
+		result.Contacts++
+	}
+
+	orderIDs := make(map[string]string)
+	for _, order := range d.Orders {
+		contactID, ok := contactIDs[contactKey(order.DocumentType, order.ContactsDocumentNumber)]
+		if !ok {
+			log.Printf("Skipping order %s: contact %s not available", order.ID, order.ContactsName)
+			result.Skipped++
+			continue
+		}
+		if order.Status == OrderChange || order.Status == OrderRefunds {
+			log.Printf("Skipping order %s in status %s", order.ID, OrderStatusName(order.Status))
+			result.Skipped++
+			continue
+		}
+
+		newID, err := replayOrder(q, order, contactID)
+		if err != nil {
+			log.Printf("Error recreating order %s: %v", order.ID, err)
+			result.Failed++
+			continue
+		}
+		orderIDs[order.ID] = newID
+		result.Orders++
+	}
+
+	for _, c := range d.Consigns {
+		newID, ok := orderIDs[c.OrderID]
+		if !ok {
+			result.Skipped++
+			continue
+		}
+		c.ID, c.OrderID, c.AccountID = "", newID, q.UID
+		if err := q.UpdateConsign(c); err != nil {
+			log.Printf("Error recreating consignment of order %s: %v", newID, err)
+			result.Failed++
+			continue
+		}
+		result.Consigns++
+	}
+
+	return result, nil
+}
+
+// replayOrder books an order like the given one, without food and
+// assurance since the snapshot records none, and advances it to its status,
+// checking every transition. It returns the new order ID.
+func replayOrder(q *Query, order DatasetOrder, contactID string) (string, error) {
+	booking, err := q.PreserveBooking(Booking{
+		TripID:           order.TrainNumber,
+		ContactsID:       contactID,
+		ContactsDocument: order.ContactsDocumentNumber,
+		SeatType:         strconv.Itoa(order.SeatClass),
+		Date:             order.Day(),
+		From:             Catalog.StationName(order.From),
+		To:               Catalog.StationName(order.To),
+		HighSpeed:        order.HighSpeed,
+		Plain:            true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	created, err := q.LocateOrder(booking)
+	if err != nil {
+		return "", err
+	}
+	booking.OrderID = created.ID
+
+	// Every transition is confirmed before the next, like warmup does
+	check := func(err error, status int) error {
+		if err != nil {
+			return err
+		}
+		return expectOrderStatus(q, booking, status)
+	}
+
+	switch order.Status {
+	case OrderCancel:
+		err = check(q.CancelOrder(booking.OrderID, q.UID), OrderCancel)
+	case OrderPaid, OrderCollected, OrderUsed:
+		err = check(q.PayOrder(booking.OrderID, booking.TripID), OrderPaid)
+		if err == nil && order.Status != OrderPaid {
+			err = check(q.CollectTicket(booking.OrderID), OrderCollected)
+		}
+		if err == nil && order.Status == OrderUsed {
+			err = check(q.EnterStation(booking.OrderID), OrderUsed)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return booking.OrderID, nil
+}
+
+// runExport writes the dataset behind url to path
+func runExport(url, path string) {
+	d, err := ExportDataset(login(url))
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+
+	log.Printf("Exported %d contacts, %d orders and %d consignments to %s",
+		len(d.Contacts), len(d.Orders), len(d.Consigns), path)
+}
+
+// runImport recreates the dataset of path behind url
+func runImport(url, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+
+	var d Dataset
+	if err := json.Unmarshal(data, &d); err != nil {
+		log.Fatalf("Import failed: failed to parse dataset: %v", err)
+	}
+
+	result, err := ImportDataset(login(url), &d)
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+
+	log.Printf("Imported %d contacts, %d orders and %d consignments from %s (%d skipped, %d failures)",
+		result.Contacts, result.Orders, result.Consigns, path, result.Skipped, result.Failed)
+}
@@ -1,18 +1,148 @@
 package main
 
 import (
+    "fmt"
     "math/rand"
+    "strconv"
+    "strings"
     "time"
 )
 
 var BaseDate time.Time
 
-func init() {
-    rand.Seed(time.Now().UnixNano())
+// Dates picks the travel date of every scenario invocation. It never mutates
+// BaseDate, so all workers can share it.
+var Dates DateStrategy
+
+// DateStrategy returns the travel date to use for one scenario invocation
+type DateStrategy interface {
+    Next(r *rand.Rand) time.Time
+    String() string
+}
+
+// UniformDate picks a day uniformly within [Base, Base+Days]
+type UniformDate struct {
+    Base time.Time
+    Days int
+}
+
+func (d UniformDate) Next(r *rand.Rand) time.Time {
+    return d.Base.AddDate(0, 0, r.Intn(d.Days+1))
+}
+
+func (d UniformDate) String() string {
+    return fmt.Sprintf("uniform within [%s, +%d days]", d.Base.Format("2006-01-02"), d.Days)
 }
 
-// UpdateBaseDate sets BaseDate to a random date within 30 days of the original BaseDate
-func UpdateBaseDate() {
-    daysToAdd := rand.Intn(30) // Random number of days to add (0 to 29)
-    BaseDate = BaseDate.AddDate(0, 0, daysToAdd)
-}
\ No newline at end of file
+// FixedDate always returns the same date
+type FixedDate struct {
+    Date time.Time
+}
+
+func (d FixedDate) Next(r *rand.Rand) time.Time {
+    return d.Date
+}
+
+func (d FixedDate) String() string {
+    return fmt.Sprintf("fixed at %s", d.Date.Format("2006-01-02"))
+}
+
+// TodayOffset returns today plus a fixed number of days, evaluated on every call
+type TodayOffset struct {
+    Days int
+}
+
+func (d TodayOffset) Next(r *rand.Rand) time.Time {
+    now := time.Now()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    return today.AddDate(0, 0, d.Days)
+}
+
+func (d TodayOffset) String() string {
+    return fmt.Sprintf("today + %d days", d.Days)
+}
+
+// NearTermDate picks Base+i days with probability proportional to Weights[i],
+// modelling travellers who mostly book for the coming days
+type NearTermDate struct {
+    Base    time.Time
+    Weights []int
+}
+
+func (d NearTermDate) Next(r *rand.Rand) time.Time {
+    total := 0
+    for _, w := range d.Weights {
+        total += w
+    }
+    n := r.Intn(total)
+    for i, w := range d.Weights {
+        n -= w
+        if n < 0 {
+            return d.Base.AddDate(0, 0, i)
+        }
+    }
+    return d.Base
+}
+
+func (d NearTermDate) String() string {
+    return fmt.Sprintf("near-term from %s with day weights %v", d.Base.Format("2006-01-02"), d.Weights)
+}
+
+// ParseDateStrategy builds a DateStrategy from a spec of the form
+// "uniform:<days>", "fixed", "today:<offset>" or "nearterm:<w0>,<w1>,...".
+// base is the BASE_DATE given on the command line.
+func ParseDateStrategy(spec string, base time.Time) (DateStrategy, error) {
+    kind, arg, _ := strings.Cut(spec, ":")
+
+    switch kind {
+    case "uniform":
+        days := 29
+        if arg != "" {
+            n, err := strconv.Atoi(arg)
+            if err != nil || n < 0 {
+                return nil, fmt.Errorf("invalid uniform range %q", arg)
+            }
+            days = n
+        }
+        return UniformDate{Base: base, Days: days}, nil
+    case "fixed":
+        if arg == "" {
+            return FixedDate{Date: base}, nil
+        }
+        date, err := time.Parse("2006-01-02", arg)
+        if err != nil {
+            return nil, fmt.Errorf("invalid fixed date %q: %v", arg, err)
+        }
+        return FixedDate{Date: date}, nil
+    case "today":
+        days := 0
+        if arg != "" {
+            n, err := strconv.Atoi(arg)
+            if err != nil {
+                return nil, fmt.Errorf("invalid today offset %q", arg)
+            }
+            days = n
+        }
+        return TodayOffset{Days: days}, nil
+    case "nearterm":
+        weights := []int{8, 6, 4, 3, 2, 2, 1}
+        if arg != "" {
+            weights = weights[:0]
+            total := 0
+            for _, field := range strings.Split(arg, ",") {
+                w, err := strconv.Atoi(strings.TrimSpace(field))
+                if err != nil || w < 0 {
+                    return nil, fmt.Errorf("invalid near-term weight %q", field)
+                }
+                weights = append(weights, w)
+                total += w
+            }
+            if total == 0 {
+                return nil, fmt.Errorf("near-term weights must not all be zero")
+            }
+        }
+        return NearTermDate{Base: base, Weights: weights}, nil
+    default:
+        return nil, fmt.Errorf("unknown date strategy %q", kind)
+    }
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FamilyCustom is the family of the scenarios loaded from a scenario file
+const FamilyCustom = "custom"
+
+// ScenarioFile is the top level of a scenario file. Being YAML, JSON files
+// are accepted as well. See scenarios.example.yaml.
+type ScenarioFile struct {
+	Scenarios []ScenarioDef `yaml:"scenarios"`
+}
+
+// ScenarioDef describes a scenario as a sequence of steps
+type ScenarioDef struct {
+	Name   string    `yaml:"name"`
+	Weight float64   `yaml:"weight"`
+	Steps  []StepDef `yaml:"steps"`
+}
+
+// StepDef is one step of a scenario. The "branch" action runs one of its
+// branches, picked by probability; if the probabilities add up to less
+// than one, the remainder runs no branch.
+type StepDef struct {
+	Action     string `yaml:"action"`
+	StepParams `yaml:",inline"`
+	// Probability of running the step at all, default 1
+	Probability *float64 `yaml:"probability"`
+	// Think is the pause after the step, such as "500ms" or "exp:2s",
+	// default the -think-step setting
+	Think *ThinkTime `yaml:"think"`
+	// OnFailure is "stop" (default) to end the scenario or "continue"
+	OnFailure string      `yaml:"onFailure"`
+	Branches  []BranchDef `yaml:"branches"`
+}
+
+type BranchDef struct {
+	Probability float64   `yaml:"probability"`
+	Steps       []StepDef `yaml:"steps"`
+}
+
+// LoadScenarioFile reads scenario definitions and turns them into scenarios
+// of the custom family
+func LoadScenarioFile(path string) ([]Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %v", err)
+	}
+
+	var file ScenarioFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario file: %v", err)
+	}
+
+	if len(file.Scenarios) == 0 {
+		return nil, fmt.Errorf("scenario file %s defines no scenarios", path)
+	}
+
+	scenarios := make([]Scenario, 0, len(file.Scenarios))
+	defined := make(map[string]bool, len(file.Scenarios))
+	for i := range file.Scenarios {
+		def := &file.Scenarios[i]
+		if def.Name == "" {
+			return nil, fmt.Errorf("scenario %d has no name", i)
+		}
+		if findScenario(def.Name) != nil {
+			return nil, fmt.Errorf("scenario %s already exists", def.Name)
+		}
+		if defined[def.Name] {
+			return nil, fmt.Errorf("scenario %s is defined twice in %s", def.Name, path)
+		}
+		defined[def.Name] = true
+		if err := validateSteps(def.Steps); err != nil {
+			return nil, fmt.Errorf("scenario %s: %v", def.Name, err)
+		}
+
+		scenarios = append(scenarios, Scenario{
+			Name:     def.Name,
+			Function: func(q *Query) { RunScenarioDef(q, def) },
+			Family:   FamilyCustom,
+			Weight:   def.Weight,
+		})
+	}
+
+	return scenarios, nil
+}
+
+func validateSteps(steps []StepDef) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+
+	for i := range steps {
+		step := &steps[i]
+
+		if step.Action == "branch" {
+			total := 0.0
+			for _, branch := range step.Branches {
+				total += branch.Probability
+				if err := validateSteps(branch.Steps); err != nil {
+					return fmt.Errorf("step %d branch: %v", i, err)
+				}
+			}
+			if total > 1 {
+				return fmt.Errorf("step %d: branch probabilities add up to %.2f", i, total)
+			}
+		} else if _, ok := StepActions[step.Action]; !ok {
+			return fmt.Errorf("step %d: unknown action %q (known: branch, %s)", i, step.Action, stepNames())
+		}
+
+		if step.Probability != nil && (*step.Probability < 0 || *step.Probability > 1) {
+			return fmt.Errorf("step %d: probability must be within [0, 1]", i)
+		}
+
+		if step.Think != nil {
+			if err := step.Think.Validate(); err != nil {
+				return fmt.Errorf("step %d: %v", i, err)
+			}
+		}
+
+		switch step.OnFailure {
+		case "", "stop", "continue":
+		default:
+			return fmt.Errorf("step %d: onFailure must be stop or continue", i)
+		}
+	}
+
+	return nil
+}
+
+// RunScenarioDef interprets a scenario definition with the Query methods
+func RunScenarioDef(q *Query, def *ScenarioDef) {
+	log.Printf("Starting %s operation", def.Name)
+
+	st := &JourneyState{}
+	defer st.ReleaseOrders()
+	if runSteps(q, st, def.Name, def.Steps) {
+		log.Printf("%s completed", def.Name)
+	}
+}
+
+// runSteps runs steps in order and reports whether the scenario should go on
+func runSteps(q *Query, st *JourneyState, name string, steps []StepDef) bool {
+	for i, step := range steps {
+		if step.Probability != nil && q.Rand.Float64() >= *step.Probability {
+			continue
+		}
+
+		if step.Action == "branch" {
+			n := q.Rand.Float64()
+			for _, branch := range step.Branches {
+				n -= branch.Probability
+				if n < 0 {
+					if !runSteps(q, st, name, branch.Steps) {
+						return false
+					}
+					break
+				}
+			}
+		} else if err := RunStep(q, st, name, step.Action, step.StepParams); err != nil {
+			log.Printf("%s: step %s failed: %v", name, step.Action, err)
+			if step.OnFailure != "continue" {
+				return false
+			}
+		}
+
+		think := StepThink
+		if step.Think != nil {
+			think = *step.Think
+		} else if i == len(steps)-1 {
+			continue
+		}
+		if !q.Think(think.Sample(q.Rand)) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fault is the random fault target of a load test, nil if none
+var Fault *FaultConfig
+
+// FaultConfig describes how a load test picks the service it perturbs and
+// the burst parameters it sets there
+type FaultConfig struct {
+	// Candidates are the services a target is picked from, with equal chance
+	Candidates []string `yaml:"candidates"`
+	// Seed makes the choice reproducible, see ChoiceSeed
+	Seed     int64    `yaml:"seed"`
+	Period   IntRange `yaml:"period"`
+	Rate     IntRange `yaml:"rate"`
+	Duration IntRange `yaml:"duration"`
+}
+
+// IntRange is an inclusive range of integers
+type IntRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// UnmarshalYAML accepts "<min>-<max>", a single number or {min, max}
+func (r *IntRange) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		type plain IntRange
+		return node.Decode((*plain)(r))
+	}
+
+	minStr, maxStr, isRange := strings.Cut(node.Value, "-")
+	if !isRange {
+		maxStr = minStr
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(minStr))
+	if err != nil {
+		return fmt.Errorf("invalid range %q: %v", node.Value, err)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(maxStr))
+	if err != nil {
+		return fmt.Errorf("invalid range %q: %v", node.Value, err)
+	}
+	r.Min, r.Max = min, max
+	return nil
+}
+
+func (r IntRange) sample(rng *rand.Rand) int {
+	return r.Min + rng.Intn(r.Max-r.Min+1)
+}
+
+func (r IntRange) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// DefaultFaultConfig picks one of the services prepare-bursty-service.sh
+// selects from, with bursts of 5-30s at 1-10 req/s every 30-120s
+func DefaultFaultConfig() *FaultConfig {
+	return &FaultConfig{
+		Candidates: []string{"ts-cancel-service", "ts-basic-service", "ts-travel-service", "ts-seat-service"},
+		Period:     IntRange{30, 120},
+		Rate:       IntRange{1, 10},
+		Duration:   IntRange{5, 30},
+	}
+}
+
+// LoadFaultConfig reads a fault configuration from a YAML or JSON file, or
+// returns the default configuration for "builtin". Unset fields of a file
+// keep their defaults.
+func LoadFaultConfig(path string) (*FaultConfig, error) {
+	config := DefaultFaultConfig()
+
+	if path != "builtin" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fault configuration: %v", err)
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse fault configuration: %v", err)
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *FaultConfig) validate() error {
+	if len(c.Candidates) == 0 {
+		return fmt.Errorf("no candidate services")
+	}
+	for _, service := range c.Candidates {
+		if _, err := Services.Lookup(service); err != nil {
+			return fmt.Errorf("candidate %v", err)
+		}
+	}
+
+	for _, r := range []struct {
+		name string
+		IntRange
+	}{{"period", c.Period}, {"rate", c.Rate}, {"duration", c.Duration}} {
+		if r.Min <= 0 || r.Max < r.Min {
+			return fmt.Errorf("%s range needs 0 < min <= max", r.name)
+		}
+	}
+	if c.Duration.Min > c.Period.Max {
+		return fmt.Errorf("duration range %s never fits into period range %s", c.Duration, c.Period)
+	}
+
+	return nil
+}
+
+func (c *FaultConfig) String() string {
+	return fmt.Sprintf("one of %s, period %ss, rate %s req/s, duration %ss",
+		strings.Join(c.Candidates, ", "), c.Period, c.Rate, c.Duration)
+}
+
+// FaultChoice is the target and burst parameters picked for a run
+type FaultChoice struct {
+	Service string      `json:"service"`
+	Params  BurstParams `json:"params"`
+	Seed    int64       `json:"seed"`
+	// Original holds the parameters the service had before, restored afterwards
+	Original BurstParams `json:"original"`
+	// Injection and Reset are the outcomes of applying Params and Original
+	Injection BurstOutcome  `json:"injection"`
+	Reset     *BurstOutcome `json:"reset,omitempty"`
+}
+
+// ChoiceSeed is the seed of the choice: a non-zero -fault-seed (override)
+// wins over a non-zero Seed of the configuration, which wins over the run seed
+func (c *FaultConfig) ChoiceSeed(override int64) int64 {
+	if override != 0 {
+		return override
+	}
+	if c.Seed != 0 {
+		return c.Seed
+	}
+	return Seed
+}
+
+// Pick draws a target service and burst parameters from the configuration.
+// A burst never lasts longer than its period.
+func (c *FaultConfig) Pick(seed int64) FaultChoice {
+	r := rand.New(rand.NewSource(seed))
+
+	choice := FaultChoice{Service: c.Candidates[r.Intn(len(c.Candidates))], Seed: seed}
+	choice.Params.Period = c.Period.sample(r)
+	choice.Params.Rate = c.Rate.sample(r)
+
+	duration := c.Duration
+	if duration.Max > choice.Params.Period {
+		duration.Max = choice.Params.Period
+	}
+	if duration.Min > duration.Max {
+		duration.Min = duration.Max
+	}
+	choice.Params.Duration = duration.sample(r)
+
+	return choice
+}
+
+// InjectFault picks a target with seed and applies its burst parameters,
+// remembering the parameters the target had before for ResetFault
+func InjectFault(client *ControlClient, config *FaultConfig, seed int64) *FaultChoice {
+	choice := config.Pick(seed)
+
+	original, err := client.GetBurstParams(choice.Service)
+	if err != nil {
+		log.Printf("Random fault: %v, reset will disable bursts of %s", err, choice.Service)
+	}
+	choice.Original = original
+
+	log.Printf("Random fault: %s with %s (seed %d)", choice.Service, choice.Params, seed)
+	choice.Injection = applyBurstParams(client, choice.Service, choice.Params, "random fault")
+	logBurstOutcome("apply "+choice.Service, choice.Params, choice.Injection)
+	return &choice
+}
+
+// ResetFault restores the parameters the target had before the fault
+func ResetFault(client *ControlClient, choice *FaultChoice) {
+	outcome := applyBurstParams(client, choice.Service, choice.Original, "random fault reset")
+	choice.Reset = &outcome
+	logBurstOutcome("reset "+choice.Service, choice.Original, outcome)
+}
+
+// logBurstOutcome logs a fault change and records it in the time series
+func logBurstOutcome(what string, params BurstParams, outcome BurstOutcome) {
+	switch {
+	case !outcome.Applied:
+		log.Printf("Random fault: %s failed: %s", what, outcome.Error)
+		series.Event("fault %s %s failed: %s", what, params, outcome.Error)
+		return
+	case !outcome.Acknowledged:
+		log.Printf("Random fault: %s to %s applied, but not acknowledged: %s", what, params, outcome.Error)
+	default:
+		log.Printf("Random fault: %s to %s applied and acknowledged", what, params)
+	}
+	series.Event("fault %s period=%d rate=%d duration=%d acknowledged=%t", what, params.Period, params.Rate, params.Duration, outcome.Acknowledged)
+}
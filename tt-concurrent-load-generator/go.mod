@@ -2,4 +2,7 @@ module github.com/docc-lab/train-ticket-auto-query/tt-concurrent-load-generator
 
 go 1.18
 
-require golang.org/x/sync v0.8.0
+require (
+	golang.org/x/sync v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -8,6 +8,12 @@ type LoginManager struct {
 
 var Manager LoginManager
 
+// Credentials of the Train-Ticket admin account used by the admin scenarios
+var (
+	AdminUsername = "admin"
+	AdminPassword = "222222"
+)
+
 func Init() {
 
 }
@@ -1,16 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"golang.org/x/sync/semaphore"
-	"io"
 	"log"
-	"math/rand"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -22,8 +17,20 @@ var (
 	ThreadCount     int
 	DurationSeconds int
 	stats           *ScenarioStats
-	activeScenarios []byte
+	activeScenarios []Scenario
+	sessionModel    *SessionModel
+	sessionStats    *SessionStats
 	sem             *semaphore.Weighted
+
+	// BurstSchedule lists the burst parameter changes applied during a load test
+	BurstSchedule []BurstChange
+	// TimeSeriesPath is the CSV file of the load-test time series, empty for none
+	TimeSeriesPath     string
+	TimeSeriesInterval time.Duration
+	// FaultSeed overrides the seed of the random fault choice
+	FaultSeed int64
+	// ReportPath is the JSON run report of a load test, empty for none
+	ReportPath string
 )
 
 func main() {
@@ -31,8 +38,42 @@ func main() {
 	isWarmup := flag.Bool("warmup", false, "Run in warm-up mode")
 	isSetParams := flag.Bool("setparams", false, "Set burst parameters")
 	isGetParams := flag.Bool("getparams", false, "Get burst parameters")
+	isListParams := flag.Bool("listparams", false, "List the burst parameters of every registered service")
+	servicesFile := flag.String("services", "", "YAML or JSON registry mapping service names to their burst control endpoints (default: the built-in services)")
+	isVerify := flag.Bool("verify", false, "Verify the stored orders against the warm-up targets, exiting non-zero if out of tolerance")
+	exportFile := flag.String("export", "", "Write the contacts, orders and consignments of the generator account to this JSON file")
+	importFile := flag.String("import", "", "Recreate the dataset of this JSON file (see -export) by replaying bookings")
+	isCleanup := flag.Bool("cleanup", false, "Cancel the unpaid and paid orders of the generator account, see -cleanup-delete")
+	cleanupDelete := flag.Bool("cleanup-delete", false, "Also delete the matching orders through the admin order service")
+	cleanupFrom := flag.String("cleanup-from", "", "Only clean up orders travelling on or after this day (YYYY-MM-DD)")
+	cleanupTo := flag.String("cleanup-to", "", "Only clean up orders travelling on or before this day (YYYY-MM-DD)")
+	cleanupStatus := flag.String("cleanup-status", "", "Only clean up orders in these statuses, e.g. \"NOTPAID,PAID\" (default all)")
+	dryRun := flag.Bool("dry-run", false, "With -cleanup, only list the matching orders and what would happen to them")
+	verifyTolerance := flag.Float64("verify-tolerance", VerifyTolerance, "Relative deviation from a warm-up target that verification accepts")
+	dateStrategy := flag.String("date-strategy", "uniform:29", "Travel date per scenario: uniform:<days>, fixed[:<date>], today:<offset> or nearterm:<w0>,<w1>,...")
+	catalog := flag.String("catalog", "", "Station pair catalog: a JSON file, \"discover\" to build it from the routes of the running system, or empty for the defaults")
+	scenarioSpec := flag.String("scenarios", "", "Weighted scenario selection, e.g. \"user=4,admin=1\" or \"QueryAndPreserve=3,QueryAndPay\" (replaces SCENARIO_FLAGS; default: the original eight scenarios)")
+	scenarioFile := flag.String("scenario-file", "", "YAML or JSON file with custom scenario definitions (family \"custom\")")
+	sessionFile := flag.String("session", "", "Run Markov-chain user sessions instead of scenarios: a YAML/JSON session model or \"builtin\"")
+	thinkStep := flag.String("think-step", "none", "Think time between the steps of a scenario: none, <duration>, fixed:<d>, uniform:<min>-<max>, exp:<mean> or lognormal:<median>,<sigma>")
+	thinkScenario := flag.String("think-scenario", "none", "Think time between the scenarios (or sessions) of a worker, same format as -think-step")
+	adminUser := flag.String("admin-user", AdminUsername, "Admin account used by the admin scenarios")
+	adminPassword := flag.String("admin-password", AdminPassword, "Password of the admin account")
+	warmupTargets := flag.String("warmup-targets", "", "Warm-up orders per state, e.g. \"unpaid=1000,paid=500,cancelled=100\", or a YAML/JSON file with that mapping (states: "+warmupStateNames()+")")
+	hsWeights := flag.String("highspeed-weights", "60:40", "Relative share of high-speed and normal-train orders, <highspeed>:<normal>, used by scenarios and warmup")
+	burstSchedule := flag.String("burst-schedule", "", "Burst parameter changes applied during the load test, e.g. \"120s:ts-seat-service=60/5/10,300s:ts-seat-service=reset\", or a YAML/JSON file with a schedule list")
+	timeSeries := flag.String("timeseries", "", "Write the load-test throughput per interval and every burst change to this CSV file")
+	timeSeriesInterval := flag.Duration("timeseries-interval", time.Second, "Sampling interval of the -timeseries output")
+	randomFault := flag.String("random-fault", "", "Burst a randomly picked service during the load test and reset it afterwards: a YAML/JSON file with candidates and parameter ranges, or \"builtin\"")
+	faultSeed := flag.Int64("fault-seed", 0, "Seed of the -random-fault choice, overriding the seed of the fault configuration (0: that seed, or else the run seed)")
+	reportFile := flag.String("report", "", "Write a JSON run report, including the -random-fault choice, to this file")
+	annotationsFile := flag.String("annotations", "", "Write ground-truth annotations (run start/stop, phases, burst changes and acknowledgements) to this NDJSON file")
+	seed := flag.Int64("seed", 0, "Random seed for reproducible runs (0 derives one from the current time)")
 	flag.Parse()
 
+	InitSeed(*seed)
+	AdminUsername, AdminPassword = *adminUser, *adminPassword
+
 	args := flag.Args()
 
 	// Check arguments based on mode
@@ -43,21 +84,54 @@ func main() {
 		}
 	} else if *isSetParams {
 		if len(args) != 5 {
-			fmt.Println("SetParams mode usage: ./tt-concurrent-load-generator -getparams <TRAIN_TICKET_UI_IPADDR> <BURSTY_SERVICE> <BURST_PERIOD> <BURST_RATE> <BURST_DURATION>")
+			fmt.Println("SetParams mode usage: ./tt-concurrent-load-generator -setparams <TRAIN_TICKET_UI_IPADDR> <BURSTY_SERVICE> <BURST_PERIOD> <BURST_RATE> <BURST_DURATION>")
 			os.Exit(1)
 		}
 	} else if *isGetParams {
 		if len(args) != 2 {
-			fmt.Println("SetParams mode usage: ./tt-concurrent-load-generator -getparams <TRAIN_TICKET_UI_IPADDR> <BURSTY_SERVICE>")
+			fmt.Println("GetParams mode usage: ./tt-concurrent-load-generator -getparams <TRAIN_TICKET_UI_IPADDR> <BURSTY_SERVICE>")
+			os.Exit(1)
+		}
+	} else if *isListParams {
+		if len(args) != 1 {
+			fmt.Println("ListParams mode usage: ./tt-concurrent-load-generator -listparams <TRAIN_TICKET_UI_IPADDR>")
+			os.Exit(1)
+		}
+	} else if *exportFile != "" || *importFile != "" {
+		if *exportFile != "" && *importFile != "" {
+			fmt.Println("Use either -export or -import, not both")
+			os.Exit(1)
+		}
+		if len(args) != 1 {
+			fmt.Println("Export/import mode usage: ./tt-concurrent-load-generator -export|-import <FILE> [-catalog <CATALOG>] <TRAIN_TICKET_UI_IPADDR>")
+			os.Exit(1)
+		}
+	} else if *isCleanup {
+		if len(args) != 1 {
+			fmt.Println("Cleanup mode usage: ./tt-concurrent-load-generator -cleanup [-cleanup-delete] [-cleanup-from <DATE>] [-cleanup-to <DATE>] [-cleanup-status <STATUSES>] [-dry-run] <TRAIN_TICKET_UI_IPADDR>")
+			os.Exit(1)
+		}
+	} else if *isVerify {
+		if len(args) != 1 {
+			fmt.Println("Verify mode usage: ./tt-concurrent-load-generator -verify [-warmup-targets <TARGETS>] <TRAIN_TICKET_UI_IPADDR>")
 			os.Exit(1)
 		}
 	} else {
 		if len(args) < 4 || len(args) > 5 {
 			fmt.Println("Load test mode usage: ./tt-concurrent-load-generator <TRAIN_TICKET_UI_IPADDR> <BASE_DATE> <NUM_THREADS> <DURATION_SECONDS> [<SCENARIO_FLAGS>]")
+			fmt.Printf("SCENARIO_FLAGS is a 0/1 bitmap over: %s\n", scenarioNames(AllScenarios))
 			os.Exit(1)
 		}
 	}
 
+	if *servicesFile != "" {
+		registry, err := LoadServiceRegistry(*servicesFile)
+		if err != nil {
+			log.Fatalf("Invalid service registry: %v", err)
+		}
+		Services = registry
+	}
+
 	if *isSetParams {
 		params := [3]int{0, 0, 0}
 		for i := 0; i < 3; i += 1 {
@@ -71,7 +145,7 @@ func main() {
 		runSetParams(
 			args[0],
 			args[1],
-			params,
+			BurstParams{Period: params[0], Rate: params[1], Duration: params[2]},
 		)
 
 		return
@@ -83,6 +157,56 @@ func main() {
 		return
 	}
 
+	if *isListParams {
+		runListParams(args[0])
+
+		return
+	}
+
+	if *exportFile != "" {
+		runExport(fmt.Sprintf("http://%s:8080", args[0]), *exportFile)
+
+		return
+	}
+
+	if *importFile != "" {
+		url := fmt.Sprintf("http://%s:8080", args[0])
+		if err := InitCatalog(*catalog, url); err != nil {
+			log.Fatalf("Failed to initialize station catalog: %v", err)
+		}
+		runImport(url, *importFile)
+
+		return
+	}
+
+	if *isCleanup {
+		filter, err := NewCleanupFilter(*cleanupFrom, *cleanupTo, *cleanupStatus)
+		if err != nil {
+			log.Fatalf("Invalid cleanup filter: %v", err)
+		}
+		runCleanup(fmt.Sprintf("http://%s:8080", args[0]), filter, *cleanupDelete, *dryRun)
+
+		return
+	}
+
+	VerifyTolerance = *verifyTolerance
+	if *warmupTargets != "" {
+		var err error
+		WarmupTargets, err = ParseWarmupTargets(*warmupTargets)
+		if err != nil {
+			log.Fatalf("Invalid warm-up targets: %v", err)
+		}
+	}
+
+	if *isVerify {
+		if err := InitCatalog(*catalog, fmt.Sprintf("http://%s:8080", args[0])); err != nil {
+			log.Fatalf("Failed to initialize station catalog: %v", err)
+		}
+		runVerify(fmt.Sprintf("http://%s:8080", args[0]))
+
+		return
+	}
+
 	ipAddr := args[0]
 	baseDate := args[1]
 	var err error
@@ -91,23 +215,78 @@ func main() {
 		log.Fatalf("Invalid thread count: %v", err)
 	}
 
-	if len(args) == 5 {
-		if len(args[4]) != 8 {
+	StepThink, err = ParseThinkTime(*thinkStep)
+	if err != nil {
+		log.Fatalf("Invalid step think time: %v", err)
+	}
+	ScenarioThink, err = ParseThinkTime(*thinkScenario)
+	if err != nil {
+		log.Fatalf("Invalid scenario think time: %v", err)
+	}
+
+	if *burstSchedule != "" {
+		BurstSchedule, err = ParseBurstSchedule(*burstSchedule)
+		if err != nil {
+			log.Fatalf("Invalid burst schedule: %v", err)
+		}
+	}
+	TimeSeriesPath, TimeSeriesInterval = *timeSeries, *timeSeriesInterval
+
+	if *randomFault != "" {
+		if len(BurstSchedule) > 0 {
+			log.Fatalf("Use either -burst-schedule or -random-fault, not both!")
+		}
+		Fault, err = LoadFaultConfig(*randomFault)
+		if err != nil {
+			log.Fatalf("Invalid fault configuration: %v", err)
+		}
+	}
+	FaultSeed, ReportPath = *faultSeed, *reportFile
+
+	if *sessionFile != "" {
+		sessionModel, err = LoadSessionModel(*sessionFile)
+		if err != nil {
+			log.Fatalf("Invalid session model: %v", err)
+		}
+	}
+
+	if len(args) == 5 && *scenarioSpec != "" {
+		log.Fatalf("Use either SCENARIO_FLAGS or -scenarios, not both!")
+	}
+
+	if *scenarioFile != "" {
+		custom, err := LoadScenarioFile(*scenarioFile)
+		if err != nil {
+			log.Fatalf("Invalid scenario file: %v", err)
+		}
+		AllScenarios = append(AllScenarios, custom...)
+		log.Printf("Loaded %d custom scenarios from %s", len(custom), *scenarioFile)
+	}
+
+	if *scenarioSpec != "" {
+		activeScenarios, err = SelectScenarios(*scenarioSpec)
+		if err != nil {
+			log.Fatalf("Invalid scenario selection: %v", err)
+		}
+	} else if len(args) == 5 {
+		// Bitmaps may be shorter than the scenario list, trailing scenarios are then disabled
+		if len(args[4]) == 0 || len(args[4]) > len(AllScenarios) {
 			log.Fatalf("Invalid bitmap length for scenarios!")
 		}
 
-		activeScenarios = make([]byte, 8)
 		for i, e := range strings.Split(args[4], "") {
-			if e == "0" {
-				activeScenarios[i] = 0
-			} else if e == "1" {
-				activeScenarios[i] = 1
-			} else {
+			if e == "1" {
+				scenario := AllScenarios[i]
+				scenario.Weight = 1
+				activeScenarios = append(activeScenarios, scenario)
+			} else if e != "0" {
 				log.Fatalf("Invalid bitmap value for scenarios!")
 			}
 		}
+	} else if *scenarioFile != "" {
+		activeScenarios, _ = SelectScenarios(FamilyCustom)
 	} else {
-		activeScenarios = []byte{1, 1, 1, 1, 1, 1, 1, 1}
+		activeScenarios, _ = SelectScenarios(DefaultScenarios)
 	}
 
 	if !*isWarmup {
@@ -119,34 +298,77 @@ func main() {
 
 	url := fmt.Sprintf("http://%s:8080", ipAddr)
 	log.Printf("Connecting to: %s", url)
+	log.Printf("Using random seed: %d", Seed)
 
 	BaseDate, err = time.Parse("2006-01-02", baseDate)
 	if err != nil {
 		log.Fatalf("Invalid date format: %v", err)
 	}
 
+	highspeedWeights, err = ParseHighSpeedWeights(*hsWeights)
+	if err != nil {
+		log.Fatalf("Invalid high-speed weights: %v", err)
+	}
+	log.Printf("High-speed to normal-train weights: %d:%d", highspeedWeights[true], highspeedWeights[false])
+
+	Dates, err = ParseDateStrategy(*dateStrategy, BaseDate)
+	if err != nil {
+		log.Fatalf("Invalid date strategy: %v", err)
+	}
+	log.Printf("Travel dates: %s", Dates)
+
+	if err := InitCatalog(*catalog, url); err != nil {
+		log.Fatalf("Failed to initialize station catalog: %v", err)
+	}
+	log.Printf("Station catalog: %d pairs", len(Catalog.Pairs))
+
+	if *annotationsFile != "" {
+		mode := "load"
+		if *isWarmup {
+			mode = "warmup"
+		}
+		annotations, err = NewAnnotations(*annotationsFile, map[string]interface{}{
+			"mode":     mode,
+			"url":      url,
+			"seed":     Seed,
+			"threads":  ThreadCount,
+			"duration": DurationSeconds,
+		})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
 	if *isWarmup {
 		runWarmup(url)
 	} else {
 		runLoadTest(url)
 	}
+
+	if err := annotations.Close("completed"); err != nil {
+		log.Printf("Failed to write annotations: %v", err)
+	}
 }
 
 func runWarmup(url string) {
 	log.Println("Starting warm-up session...")
 
 	var wg sync.WaitGroup
-	stopChan := make(chan struct{})
-	counter := NewWarmupCounter()
+	counter := NewWarmupCounter(WarmupTargets)
+	activeWarmup = counter
+	log.Printf("Warm-up targets: %s", formatWarmupTargets(WarmupTargets))
+
+	// Count what earlier runs left, so only the deficit gets created
+	annotations.Phase("resume")
+	if err := counter.Resume(login(url)); err != nil {
+		log.Fatalf("Failed to read existing orders: %v", err)
+	}
+	existing := counter.getTotalCount()
+	log.Printf("Existing orders (actual/target):\n%s", counter.Summary())
 	startTime := time.Now()
 
-	// Initialize order cache manager
-	InitOCM()
-
-	go dataFetchWorker(url, &wg, stopChan)
-
-	time.Sleep(time.Second)
-
+	// Workers follow the orders they create themselves, no order cache needed
+	annotations.Phase("warmup")
 	for i := 0; i < ThreadCount; i++ {
 		wg.Add(1)
 		go WarmupWorker(i, url, &wg, counter)
@@ -155,138 +377,71 @@ func runWarmup(url string) {
 	wg.Wait()
 	duration := time.Since(startTime)
 
-	close(stopChan)
+	log.Printf("Warm-up completed in %v. Created orders (actual/target):\n%s", duration, counter.Summary())
+	log.Printf("Total orders created: %d", counter.getTotalCount()-existing)
 
-	log.Printf("Warm-up completed in %v. Created orders:", duration)
-	log.Printf("- Unpaid orders: %d (target: 2000)", counter.unpaidCount)
-	log.Printf("- Paid orders: %d (target: 1000)", counter.paidCount)
-	log.Printf("- Collected orders: %d (target: 1000)", counter.collectedCount)
-	log.Printf("- Consigned orders: %d (target: 1000)", counter.consignedCount)
-	log.Printf("Total orders created: %d", counter.getTotalCount())
+	annotations.Phase("verify")
+	runVerify(url)
 }
 
-func runSetParams(ipAddr string, service string, params [3]int) {
-	q := NewQuery(fmt.Sprintf("http://%s:8080", ipAddr))
-
-	err := q.Login("fdse_microservice", "111111")
+// newControlClient connects a control client to the gateway at ipAddr
+func newControlClient(ipAddr string) *ControlClient {
+	client, err := NewControlClient(fmt.Sprintf("http://%s:8080", ipAddr), Services)
 	if err != nil {
-		log.Printf("Login failed: %v", err)
-		return
+		log.Fatalf("%v", err)
 	}
 	log.Printf("Login successful")
+	return client
+}
 
-	err = q.CheckAndRefreshToken()
-	if err != nil {
-		log.Fatalf("failed to check/refresh token: %v", err)
-	}
-
-	targetURL := ""
-	switch service {
-	case "ts-basic-service":
-		targetURL = fmt.Sprintf("%s/api/v1/basicservice/setBurstParams", q.Address)
-	case "ts-cancel-service":
-		targetURL = fmt.Sprintf("%s/api/v1/cancelservice/setBurstParams", q.Address)
-	case "ts-seat-service":
-		targetURL = fmt.Sprintf("%s/api/v1/seatservice/setBurstParams", q.Address)
-	case "ts-travel-service":
-		targetURL = fmt.Sprintf("%s/api/v1/travelservice/setBurstParams", q.Address)
-	case "ts-preserve-service":
-		targetURL = fmt.Sprintf("%s/api/v1/preserveservice/setBurstParams", q.Address)
-	}
-
-	jsonPayload, _ := json.Marshal(params)
-
-	req, _ := http.NewRequest("POST", targetURL, bytes.NewBuffer(jsonPayload))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+q.Token)
+func runSetParams(ipAddr string, service string, params BurstParams) {
+	client := newControlClient(ipAddr)
 
-	resp, err := q.Client.Do(req)
-	if err != nil {
-		log.Fatalf("query ticket failed: %v", err)
+	if err := client.SetBurstParams(service, params); err != nil {
+		log.Fatalf("%v", err)
 	}
-	defer resp.Body.Close()
 
-	log.Println("Successfully set burst parameters!")
+	log.Printf("Successfully set burst parameters of %s: %s", service, params)
 }
 
 func runGetParams(ipAddr string, service string) {
-	q := NewQuery(fmt.Sprintf("http://%s:8080", ipAddr))
-
-	err := q.Login("fdse_microservice", "111111")
-	if err != nil {
-		log.Printf("Login failed: %v", err)
-		return
-	}
-	log.Printf("Login successful")
-
-	err = q.CheckAndRefreshToken()
-	if err != nil {
-		log.Fatalf("failed to check/refresh token: %v", err)
-	}
-
-	targetURL := ""
-	switch service {
-	case "ts-basic-service":
-		targetURL = fmt.Sprintf("%s/api/v1/basicservice/getBurstParams", q.Address)
-	case "ts-cancel-service":
-		targetURL = fmt.Sprintf("%s/api/v1/cancelservice/getBurstParams", q.Address)
-	case "ts-seat-service":
-		targetURL = fmt.Sprintf("%s/api/v1/seatservice/getBurstParams", q.Address)
-	case "ts-travel-service":
-		targetURL = fmt.Sprintf("%s/api/v1/travelservice/getBurstParams", q.Address)
-	case "ts-preserve-service":
-		targetURL = fmt.Sprintf("%s/api/v1/preserveservice/getBurstParams", q.Address)
-	}
-
-	req, _ := http.NewRequest("GET", targetURL, nil)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+q.Token)
-
-	resp, err := q.Client.Do(req)
-	if err != nil {
-		log.Fatalf("query ticket failed: %v", err)
-	}
-	defer resp.Body.Close()
+	client := newControlClient(ipAddr)
 
-	body, err := io.ReadAll(resp.Body)
+	params, err := client.GetBurstParams(service)
 	if err != nil {
-		log.Fatalf("failed to read response body: %v", err)
+		log.Fatalf("%v", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("get parameters: %d", resp.StatusCode)
-	}
+	log.Printf("Burst parameters of %s: %s", service, params)
+}
 
-	log.Printf(string(body))
+func runListParams(ipAddr string) {
+	log.Printf("Burst parameters:\n%s", newControlClient(ipAddr).FormatBurstParams())
 }
 
 func runLoadTest(url string) {
-	// Original load test logic
-	allScenarios := []struct {
-		name     string
-		function func(*Query)
-	}{
-		{"QueryAndPreserve", QueryAndPreserve},
-		{"QueryAndPay", QueryAndPay},
-		{"QueryAndCancel", QueryAndCancel},
-		{"QueryAndCollect", QueryAndCollect},
-		{"QueryAndExecute", QueryAndExecute},
-		{"QueryAndConsign", QueryAndConsign},
-		{"QueryAndRebook", QueryAndRebook},
-		{"QueryOnlyHighSpeed", QueryOnlyHighSpeed},
-	}
-
-	scenarios := make([]struct {
-		name     string
-		function func(*Query)
-	}, 0)
+	scenarios := activeScenarios
+	if sessionModel != nil {
+		log.Printf("Session mode: starting in %s, at most %d steps per session", sessionModel.Start, sessionModel.MaxSteps)
+		sessionStats = NewSessionStats()
+	} else {
+		if len(scenarios) == 0 {
+			log.Fatalf("No scenario selected!")
+		}
 
-	for i := 0; i < 8; i += 1 {
-		if activeScenarios[i] == 1 {
-			scenarios = append(scenarios, allScenarios[i])
+		for _, scenario := range scenarios {
+			log.Printf("Scenario %s (%s), weight %.2f", scenario.Name, scenario.Family, scenario.Weight)
 		}
 	}
 
+	if Fault != nil {
+		log.Printf("Random fault: %s", Fault)
+	}
+
+	log.Printf("Think time between steps: %s", StepThink)
+	log.Printf("Think time between scenarios: %s", ScenarioThink)
+	log.Printf("Closed loop of %d users, mean think time between scenarios %v", ThreadCount, ScenarioThink.MeanDuration())
+
 	// Initialize statistics tracking
 	stats = NewScenarioStats()
 
@@ -296,12 +451,63 @@ func runLoadTest(url string) {
 	sem = semaphore.NewWeighted(int64(ThreadCount))
 
 	// Initialize order cache manager
+	annotations.Phase("setup")
 	InitOCM()
 
 	go dataFetchWorker(url, &wg, stopChan)
 
 	time.Sleep(time.Second)
 
+	if TimeSeriesPath != "" {
+		var err error
+		series, err = NewTimeSeries(TimeSeriesPath, TimeSeriesInterval)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	start := time.Now()
+
+	var background sync.WaitGroup
+	if series != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			series.Run(stopChan)
+		}()
+	}
+
+	var client *ControlClient
+	if len(BurstSchedule) > 0 || Fault != nil {
+		var err error
+		client, err = NewControlClient(url, Services)
+		if err != nil {
+			log.Fatalf("Failed to create control client: %v", err)
+		}
+	}
+
+	report := &RunReport{URL: url, Seed: Seed, Threads: ThreadCount, Duration: DurationSeconds}
+	for _, scenario := range scenarios {
+		report.Scenarios = append(report.Scenarios, scenario.Name)
+	}
+
+	if Fault != nil {
+		annotations.Phase("fault")
+		report.Fault = InjectFault(client, Fault, Fault.ChoiceSeed(FaultSeed))
+	}
+
+	if len(BurstSchedule) > 0 {
+		for _, change := range BurstSchedule {
+			log.Printf("Burst schedule: %s", change)
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			RunBurstSchedule(client, BurstSchedule, start, stopChan)
+		}()
+	}
+
+	annotations.Phase("load")
+	report.Started = time.Now()
 	for i := 0; i < ThreadCount; i++ {
 		wg.Add(1)
 		go worker(i, url, scenarios, &wg, stopChan)
@@ -311,17 +517,46 @@ func runLoadTest(url string) {
 	time.Sleep(time.Duration(DurationSeconds) * time.Second)
 	close(stopChan)
 
+	annotations.Phase("drain")
 	wg.Wait()
+	background.Wait()
+	report.Finished = time.Now()
+
+	if report.Fault != nil {
+		annotations.Phase("reset")
+		ResetFault(client, report.Fault)
+	}
+
+	if series != nil {
+		if err := series.Close(); err != nil {
+			log.Printf("Failed to write time series: %v", err)
+		} else {
+			log.Printf("Time series written to %s", TimeSeriesPath)
+		}
+	}
 
 	// Print statistics
-	log.Println(stats.GetStats())
+	report.Statistics = stats.GetStats()
+	log.Println(report.Statistics)
+	if sessionStats != nil {
+		report.Statistics += sessionStats.GetStats()
+		log.Println(sessionStats.GetStats())
+	}
+
+	if report.Fault != nil {
+		log.Printf("Random fault target: %s with %s (seed %d)", report.Fault.Service, report.Fault.Params, report.Fault.Seed)
+	}
+	if ReportPath != "" {
+		if err := report.Write(ReportPath); err != nil {
+			log.Printf("%v", err)
+		} else {
+			log.Printf("Run report written to %s", ReportPath)
+		}
+	}
 	log.Println("Load test completed")
 }
 
-func worker(id int, url string, scenarios []struct {
-	name     string
-	function func(*Query)
-}, wg *sync.WaitGroup, stopChan <-chan struct{}) {
+func worker(id int, url string, scenarios []Scenario, wg *sync.WaitGroup, stopChan <-chan struct{}) {
 	defer wg.Done()
 
 	q := NewQuery(url)
@@ -333,9 +568,9 @@ func worker(id int, url string, scenarios []struct {
 	}
 	log.Printf("Worker %d: Login successful", id)
 
-	seed := time.Now().UnixNano()
-	source := rand.NewSource(seed + int64(id))
-	r := rand.New(source)
+	r := NewRand(int64(id))
+	q.Rand = r
+	q.Stop = stopChan
 
 	scenarioCount := 0
 	for {
@@ -349,18 +584,37 @@ func worker(id int, url string, scenarios []struct {
 
 			return
 		default:
-			UpdateBaseDate() // Update BaseDate to a new random date before each scenario
+			if sessionModel != nil {
+				log.Printf("Worker %d: Starting session %d", id, scenarioCount+1)
+				thought := q.Thinking()
+				result := RunSession(q, sessionModel)
+				sessionStats.Record(result)
+				stats.IncrementScenario(id, "Session")
+				// Latencies exclude think time, session durations include it
+				latency := result.Duration - (q.Thinking() - thought)
+				stats.RecordLatency("Session", latency)
+				series.RecordScenario(latency)
+				log.Printf("Worker %d: Completed session %d after %d steps (%s)", id, scenarioCount+1, result.Steps, result.EndReason)
+
+				scenarioCount++
+				sleepOrStop(ScenarioThink.Sample(r), stopChan)
+				continue
+			}
 
-			//randomIndex := rand.Intn(len(scenarios))
-			randomIndex := r.Intn(len(scenarios))
-			scenario := scenarios[randomIndex]
+			scenario := PickScenario(r, scenarios)
 
-			log.Printf("Worker %d: Starting scenario %d: %s", id, scenarioCount+1, scenario.name)
-			scenario.function(q)
-			stats.IncrementScenario(id, scenario.name)
-			log.Printf("Worker %d: Completed scenario %d: %s", id, scenarioCount+1, scenario.name)
+			log.Printf("Worker %d: Starting scenario %d: %s", id, scenarioCount+1, scenario.Name)
+			thought := q.Thinking()
+			start := time.Now()
+			scenario.Function(q)
+			latency := time.Since(start) - (q.Thinking() - thought) // Without think time
+			stats.RecordLatency(scenario.Name, latency)
+			series.RecordScenario(latency)
+			stats.IncrementScenario(id, scenario.Name)
+			log.Printf("Worker %d: Completed scenario %d: %s", id, scenarioCount+1, scenario.Name)
 
 			scenarioCount++
+			sleepOrStop(ScenarioThink.Sample(r), stopChan)
 		}
 
 		//sem.Release(1)
@@ -371,6 +625,7 @@ func dataFetchWorker(url string, wg *sync.WaitGroup, stopChan <-chan struct{}) {
 	defer wg.Done()
 
 	acquired := 0
+	r := NewRand(StreamDataFetch)
 
 	q := NewQuery(url)
 	log.Printf("Order query worker: Attempting to login")
@@ -399,7 +654,7 @@ func dataFetchWorker(url string, wg *sync.WaitGroup, stopChan <-chan struct{}) {
 					return
 				}
 				log.Printf("Order query worker: Login successful")
-				time.Sleep(time.Second * time.Duration(rand.Intn(10)+20))
+				time.Sleep(time.Second * time.Duration(r.Intn(10)+20))
 			} else {
 				_ = OCManager.QuerySem.Acquire(context.Background(), 1)
 				acquired += 1
@@ -408,3 +663,12 @@ func dataFetchWorker(url string, wg *sync.WaitGroup, stopChan <-chan struct{}) {
 		}
 	}
 }
+
+// login returns a client logged in with the generator account, or exits
+func login(url string) *Query {
+	q := NewQuery(url)
+	if err := q.Login("fdse_microservice", "111111"); err != nil {
+		log.Fatalf("Login failed: %v", err)
+	}
+	return q
+}
@@ -7,9 +7,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/http/cookiejar"
 	_url "net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,6 +41,17 @@ type Query struct {
 	//}
 	OrdersCacheOther []map[string]interface{}
 	OCTimeOther      time.Time
+	// Rand drives every random choice made on behalf of this client
+	Rand *rand.Rand
+	// admin is the admin session of this client, see AdminQuery
+	admin *Query
+	// Contact, if set, is the contact Preserve books for instead of a
+	// random contact of the account
+	Contact *Contact
+	// Stop ends think pauses early once the load test stops
+	Stop <-chan struct{}
+	// thinking is the total think time of Think, see Thinking
+	thinking time.Duration
 }
 
 func NewQuery(address string) *Query {
@@ -42,13 +59,18 @@ func NewQuery(address string) *Query {
 	return &Query{
 		Address: address,
 		Client:  &http.Client{Jar: jar},
+		Rand:    NewRand(StreamShared),
 	}
 }
 
 func (q *Query) CheckAndRefreshToken() error {
 	if time.Now().After(q.TokenExpiry) {
 		log.Println("Token expired, refreshing...")
-		err := q.Login("fdse_microservice", "111111") // You might want to store these credentials more securely
+		username, password := q.Username, q.Password
+		if username == "" {
+			username, password = "fdse_microservice", "111111" // You might want to store these credentials more securely
+		}
+		err := q.Login(username, password)
 		if err != nil {
 			return fmt.Errorf("failed to refresh token: %v", err)
 		}
@@ -66,6 +88,83 @@ func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return t.Base.RoundTrip(req)
 }
 
+// apiResponse is the envelope every Train-Ticket service wraps its payload in
+type apiResponse struct {
+	Status int             `json:"status"`
+	Msg    string          `json:"msg"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// looseString decodes JSON strings and numbers alike, Train-Ticket releases
+// disagree on whether prices and dates are serialized as strings or numbers
+type looseString string
+
+func (s *looseString) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*s = ""
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		if err := json.Unmarshal(b, &str); err != nil {
+			return err
+		}
+		*s = looseString(str)
+		return nil
+	}
+	*s = looseString(b)
+	return nil
+}
+
+// doRequest sends an authorized JSON request and decodes the data field of
+// the response envelope into out (which may be nil). A non-200 status is an
+// error, the service-level status is left to the caller.
+func (q *Query) doRequest(method, url string, payload interface{}, out interface{}) (*apiResponse, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		jsonPayload, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %v", err)
+		}
+		reqBody = bytes.NewBuffer(jsonPayload)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+q.Token)
+
+	resp, err := q.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if out != nil && len(result.Data) > 0 && string(result.Data) != "null" {
+		if err := json.Unmarshal(result.Data, out); err != nil {
+			return &result, fmt.Errorf("failed to decode response data: %v", err)
+		}
+	}
+
+	return &result, nil
+}
+
 func (q *Query) Login(username, password string) error {
 	url := fmt.Sprintf("%s/api/v1/users/login", q.Address)
 
@@ -146,6 +245,25 @@ func (q *Query) Login(username, password string) error {
 	return nil
 }
 
+// AdminQuery returns a client for the same address logged in with the admin
+// account. It is created on first use and shares the random source of q.
+func (q *Query) AdminQuery() (*Query, error) {
+	if q.admin == nil {
+		admin := NewQuery(q.Address)
+		admin.Rand = q.Rand
+		if err := admin.Login(AdminUsername, AdminPassword); err != nil {
+			return nil, fmt.Errorf("admin login failed: %v", err)
+		}
+		q.admin = admin
+	}
+
+	if err := q.admin.CheckAndRefreshToken(); err != nil {
+		return nil, err
+	}
+
+	return q.admin, nil
+}
+
 func (q *Query) QueryHighSpeedTicket(placePair [2]string, date time.Time) ([]string, string, error) {
 	return q.queryTicket(placePair, date, true)
 }
@@ -167,86 +285,115 @@ func (q *Query) queryTicket(placePair [2]string, date time.Time, isHighSpeed boo
 		"startPlace":    placePair[0],
 		"endPlace":      placePair[1],
 	}
-	jsonPayload, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := q.Client.Do(req)
-	if err != nil {
+	var trips []tripLeft
+	if _, err := q.doRequest("POST", url, payload, &trips); err != nil {
 		return nil, "", fmt.Errorf("query ticket failed: %v", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read response body: %v", err)
-	}
-	// fmt.Printf("Raw response1: %s\n", string(body))
+	tripIDs := make([]string, 0, len(trips))
+	var tripDate string
+	for _, trip := range trips {
+		if trip.TripID.Type == "" && trip.TripID.Number == "" {
+			continue
+		}
+		tripIDs = append(tripIDs, trip.TripID.String())
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("query ticket failed with status code: %d", resp.StatusCode)
+		// The date is the prefix of a "YYYY-MM-DD HH:MM:SS" start time
+		if len(trip.StartTime) >= 10 {
+			tripDate = string(trip.StartTime[:10])
+		}
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, "", fmt.Errorf("failed to decode response: %v", err)
-	}
+	return tripIDs, tripDate, nil
+}
 
-	data, ok := result["data"].([]interface{})
-	if !ok {
-		return []string{}, "", nil // Return empty slice if no data
+// tripLeft is the part of a trips/left result the generator uses
+type tripLeft struct {
+	TripID    TripID      `json:"tripId"`
+	StartTime looseString `json:"startTime"`
+}
+
+// QueryHighSpeedTicketParallel searches high-speed trips through the
+// left_parallel endpoint, which queries seats for every trip concurrently
+func (q *Query) QueryHighSpeedTicketParallel(placePair [2]string, date time.Time) ([]string, error) {
+	url := fmt.Sprintf("%s/api/v1/travelservice/trips/left_parallel", q.Address)
+	payload := map[string]string{
+		"departureTime": date.Format("2006-01-02"),
+		"startPlace":    placePair[0],
+		"endPlace":      placePair[1],
 	}
 
-	tripIDs := make([]string, 0, len(data))
-	var tripDate string
-	for _, trip := range data {
-		tripMap := trip.(map[string]interface{})
-		tripID := tripMap["tripId"].(map[string]interface{})
-		tripIDs = append(tripIDs, tripID["type"].(string)+tripID["number"].(string))
+	var trips []tripLeft
+	if _, err := q.doRequest("POST", url, payload, &trips); err != nil {
+		return nil, fmt.Errorf("query high speed ticket parallel failed: %v", err)
+	}
 
-		// Extract the date from the startTime
-		startTime := tripMap["startTime"].(string)
-		tripDate = startTime[:10] // Assuming the format is "YYYY-MM-DD HH:MM:SS"
+	tripIDs := make([]string, 0, len(trips))
+	for _, trip := range trips {
+		if trip.TripID.Type == "" && trip.TripID.Number == "" {
+			continue
+		}
+		tripIDs = append(tripIDs, trip.TripID.String())
 	}
 
-	return tripIDs, tripDate, nil
+	return tripIDs, nil
 }
 
-func (q *Query) QueryHighSpeedTicketParallel(placePair [2]string, date time.Time) ([]string, error) {
-	url := fmt.Sprintf("%s/api/v1/travelservice/trips/left_parallel", q.Address)
+// TravelPlan is one result of the travel-plan service
+type TravelPlan struct {
+	TripID                        string      `json:"tripId"`
+	TrainTypeName                 string      `json:"trainTypeName"`
+	FromStationName               string      `json:"fromStationName"`
+	ToStationName                 string      `json:"toStationName"`
+	StopStations                  []string    `json:"stopStations"`
+	PriceForSecondClassSeat       looseString `json:"priceForSecondClassSeat"`
+	NumberOfRestTicketSecondClass int         `json:"numberOfRestTicketSecondClass"`
+	PriceForFirstClassSeat        looseString `json:"priceForFirstClassSeat"`
+	NumberOfRestTicketFirstClass  int         `json:"numberOfRestTicketFirstClass"`
+}
+
+const (
+	PlanCheapest   = "cheapest"
+	PlanQuickest   = "quickest"
+	PlanMinStation = "minStation"
+)
+
+var PlanTypes = []string{PlanCheapest, PlanQuickest, PlanMinStation}
+
+// QueryTravelPlan asks the travel-plan service for the cheapest, quickest or
+// minStation plans, which fan out to the route-plan and both travel services
+func (q *Query) QueryTravelPlan(planType string, placePair [2]string, date time.Time) ([]TravelPlan, error) {
+	url := fmt.Sprintf("%s/api/v1/travelplanservice/travelPlan/%s", q.Address, planType)
+
 	payload := map[string]string{
 		"departureTime": date.Format("2006-01-02"),
 		"startPlace":    placePair[0],
 		"endPlace":      placePair[1],
 	}
-	jsonPayload, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := q.Client.Do(req)
+	var plans []TravelPlan
+	resp, err := q.doRequest("POST", url, payload, &plans)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("query %s travel plan failed: %v", planType, err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("query high speed ticket parallel failed with status code: %d", resp.StatusCode)
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("query %s travel plan failed: %s", planType, resp.Msg)
 	}
 
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
+	return plans, nil
+}
 
-	data := result["data"].([]interface{})
-	tripIDs := make([]string, len(data))
-	for i, trip := range data {
-		tripMap := trip.(map[string]interface{})
-		tripID := tripMap["tripId"].(map[string]interface{})
-		tripIDs[i] = tripID["type"].(string) + tripID["number"].(string)
-	}
+func (q *Query) QueryCheapest(placePair [2]string, date time.Time) ([]TravelPlan, error) {
+	return q.QueryTravelPlan(PlanCheapest, placePair, date)
+}
 
-	return tripIDs, nil
+func (q *Query) QueryQuickest(placePair [2]string, date time.Time) ([]TravelPlan, error) {
+	return q.QueryTravelPlan(PlanQuickest, placePair, date)
+}
+
+func (q *Query) QueryMinStation(placePair [2]string, date time.Time) ([]TravelPlan, error) {
+	return q.QueryTravelPlan(PlanMinStation, placePair, date)
 }
 
 func (q *Query) QueryOrders(orderTypes []int, queryOther bool) ([][2]string, error) {
@@ -379,6 +526,9 @@ func (q *Query) QueryOrders(orderTypes []int, queryOther bool) ([][2]string, err
 				if int(status) == t {
 					id, ok1 := order["orderId"].(string)
 					trainNumber, ok2 := order["trainNumber"].(string)
+					if ok1 && orderClaimed(id) {
+						break // Owned by a journey that follows its own order
+					}
 					if ok1 && ok2 {
 						pair := [2]string{id, trainNumber}
 						pairs = append(pairs, pair)
@@ -400,6 +550,9 @@ func (q *Query) QueryOrders(orderTypes []int, queryOther bool) ([][2]string, err
 				if int(status) == t {
 					id, ok1 := order["orderId"].(string)
 					trainNumber, ok2 := order["trainNumber"].(string)
+					if ok1 && orderClaimed(id) {
+						break // Owned by a journey that follows its own order
+					}
 					if ok1 && ok2 {
 						pair := [2]string{id, trainNumber}
 						pairs = append(pairs, pair)
@@ -509,162 +662,358 @@ func (q *Query) EnterStation(orderID string) error {
 	return nil
 }
 
-func (q *Query) QueryAssurances() ([]map[string]string, error) {
+type AssuranceType struct {
+	Index int     `json:"index"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+func (q *Query) QueryAssurances() ([]AssuranceType, error) {
 	url := fmt.Sprintf("%s/api/v1/assuranceservice/assurances/types", q.Address)
 
-	req, _ := http.NewRequest("GET", url, nil)
-	resp, err := q.Client.Do(req)
-	if err != nil {
-		return nil, err
+	var types []AssuranceType
+	if _, err := q.doRequest("GET", url, nil, &types); err != nil {
+		return nil, fmt.Errorf("query assurances failed: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("query assurances failed with status code: %d", resp.StatusCode)
-	}
+	return types, nil
+}
 
-	// As per the Python implementation, we're returning a fixed value
-	return []map[string]string{{"assurance": "1"}}, nil
+type Food struct {
+	FoodName string  `json:"foodName"`
+	Price    float64 `json:"price"`
 }
 
-func (q *Query) QueryFood(placePair [2]string, trainNum string) ([]map[string]interface{}, error) {
-	url := fmt.Sprintf("%s/api/v1/foodservice/foods/%s/%s/%s/%s", q.Address, time.Now().Format("2006-01-02"), placePair[0], placePair[1], trainNum)
+// TrainFood and FoodStore list their items under "foodList" in older
+// Train-Ticket releases and under "foods" in newer ones
+type TrainFood struct {
+	TripID   string `json:"tripId"`
+	FoodList []Food `json:"foodList"`
+	Foods    []Food `json:"foods"`
+}
 
-	req, _ := http.NewRequest("GET", url, nil)
-	resp, err := q.Client.Do(req)
+type FoodStore struct {
+	StationID string `json:"stationId"`
+	StoreName string `json:"storeName"`
+	FoodList  []Food `json:"foodList"`
+	Foods     []Food `json:"foods"`
+}
+
+type AllTripFood struct {
+	TrainFoodList    []TrainFood            `json:"trainFoodList"`
+	FoodStoreListMap map[string][]FoodStore `json:"foodStoreListMap"`
+}
+
+const (
+	FoodTypeNone    = 0
+	FoodTypeTrain   = 1
+	FoodTypeStation = 2
+)
+
+// FoodOption is one food item that can be attached to a preserve request
+type FoodOption struct {
+	FoodName    string
+	FoodPrice   float64
+	FoodType    int
+	StationName string
+	StoreName   string
+}
+
+// QueryFood returns the train food and station food available on a trip
+func (q *Query) QueryFood(date string, placePair [2]string, tripID string) ([]FoodOption, error) {
+	url := fmt.Sprintf("%s/api/v1/foodservice/foods/%s/%s/%s/%s", q.Address, date, placePair[0], placePair[1], tripID)
+
+	var all AllTripFood
+	resp, err := q.doRequest("GET", url, nil, &all)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("query food failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("query food failed: %s", resp.Msg)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("query food failed with status code: %d", resp.StatusCode)
+	options := make([]FoodOption, 0)
+	for _, trainFood := range all.TrainFoodList {
+		for _, food := range append(trainFood.FoodList, trainFood.Foods...) {
+			options = append(options, FoodOption{
+				FoodName:  food.FoodName,
+				FoodPrice: food.Price,
+				FoodType:  FoodTypeTrain,
+			})
+		}
+	}
+
+	// Sort the stations so a seeded run sees the options in the same order
+	stations := make([]string, 0, len(all.FoodStoreListMap))
+	for station := range all.FoodStoreListMap {
+		stations = append(stations, station)
 	}
+	sort.Strings(stations)
 
-	// As per the Python implementation, we're returning a fixed value
-	return []map[string]interface{}{
-		{
-			"foodName":    "Soup",
-			"foodPrice":   3.7,
-			"foodType":    2,
-			"stationName": "Su Zhou",
-			"storeName":   "Roman Holiday",
-		},
-	}, nil
+	for _, station := range stations {
+		for _, store := range all.FoodStoreListMap[station] {
+			for _, food := range append(store.FoodList, store.Foods...) {
+				options = append(options, FoodOption{
+					FoodName:    food.FoodName,
+					FoodPrice:   food.Price,
+					FoodType:    FoodTypeStation,
+					StationName: station,
+					StoreName:   store.StoreName,
+				})
+			}
+		}
+	}
+
+	return options, nil
+}
+
+// Document types of a contact
+const (
+	DocumentIDCard   = 1
+	DocumentPassport = 2
+	DocumentOther    = 3
+)
+
+// Contact is a passenger of ts-contacts-service tickets can be booked for
+type Contact struct {
+	ID             string `json:"id"`
+	AccountID      string `json:"accountId"`
+	Name           string `json:"name"`
+	DocumentType   int    `json:"documentType"`
+	DocumentNumber string `json:"documentNumber"`
+	PhoneNumber    string `json:"phoneNumber"`
+}
+
+// NewContact returns a contact of the logged in account with a random name,
+// document and phone number
+func NewContact(r *rand.Rand, accountID string) Contact {
+	return Contact{
+		AccountID:      accountID,
+		Name:           RandomString(r, 4+r.Intn(8)),
+		DocumentType:   DocumentIDCard + r.Intn(3),
+		DocumentNumber: RandomString(r, 3) + RandomPhone(r),
+		PhoneNumber:    RandomPhone(r),
+	}
 }
 
-func (q *Query) QueryContacts() ([]string, error) {
+// QueryContacts lists the contacts of the logged in account
+func (q *Query) QueryContacts() ([]Contact, error) {
 	url := fmt.Sprintf("%s/api/v1/contactservice/contacts/account/%s", q.Address, q.UID)
 
-	req, err := http.NewRequest("GET", url, nil)
+	var contacts []Contact
+	resp, err := q.doRequest("GET", url, nil, &contacts)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		log.Printf("Error querying contacts: %v", err)
+		return nil, fmt.Errorf("failed to query contacts: %v", err)
+	}
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("failed to query contacts: %s", resp.Msg)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+q.Token)
+	log.Printf("Found %d contacts", len(contacts))
+	return contacts, nil
+}
 
-	log.Printf("Sending request to %s", url)
-	resp, err := q.Client.Do(req)
+// AddContact creates a contact and returns it with the ID the service
+// assigned
+func (q *Query) AddContact(c Contact) (*Contact, error) {
+	url := fmt.Sprintf("%s/api/v1/contactservice/contacts", q.Address)
+
+	var created Contact
+	resp, err := q.doRequest("POST", url, c, &created)
 	if err != nil {
-		log.Printf("Error sending request: %v", err)
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("add contact failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("add contact failed: %s", resp.Msg)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	if created.ID == "" {
+		// Some releases do not echo the contact, look it up by document
+		contacts, err := q.QueryContacts()
+		if err != nil {
+			return nil, err
+		}
+		for i := range contacts {
+			if contacts[i].DocumentNumber == c.DocumentNumber && contacts[i].DocumentType == c.DocumentType {
+				return &contacts[i], nil
+			}
+		}
+		return nil, fmt.Errorf("added contact %s not found", c.Name)
 	}
 
-	log.Printf("Response status: %d", resp.StatusCode)
-	// log.Printf("Response body: %s", string(body))
+	return &created, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Non-OK status code: %d", resp.StatusCode)
-		return nil, fmt.Errorf("failed to query contacts: status %d, body: %s", resp.StatusCode, string(body))
-	}
+// ModifyContact updates the contact with c.ID
+func (q *Query) ModifyContact(c Contact) error {
+	url := fmt.Sprintf("%s/api/v1/contactservice/contacts", q.Address)
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		log.Printf("Error unmarshaling JSON: %v", err)
-		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	resp, err := q.doRequest("PUT", url, c, nil)
+	if err != nil {
+		return fmt.Errorf("modify contact failed: %v", err)
 	}
-
-	data, ok := result["data"].([]interface{})
-	if !ok {
-		log.Printf("Unexpected response structure: %+v", result)
-		return nil, fmt.Errorf("unexpected response structure: %+v", result)
+	if resp.Status != 1 {
+		return fmt.Errorf("modify contact failed: %s", resp.Msg)
 	}
 
-	contactIDs := make([]string, len(data))
-	for i, contact := range data {
-		contactMap, ok := contact.(map[string]interface{})
-		if !ok {
-			log.Printf("Unexpected contact structure: %+v", contact)
-			return nil, fmt.Errorf("unexpected contact structure: %+v", contact)
-		}
-		contactIDs[i] = contactMap["id"].(string)
+	return nil
+}
+
+// DeleteContact deletes a contact
+func (q *Query) DeleteContact(contactID string) error {
+	url := fmt.Sprintf("%s/api/v1/contactservice/contacts/%s", q.Address, contactID)
+
+	resp, err := q.doRequest("DELETE", url, nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete contact failed: %v", err)
 	}
+	if resp.Status != 1 {
+		return fmt.Errorf("delete contact failed: %s", resp.Msg)
+	}
+
+	return nil
+}
 
-	log.Printf("Found %d contacts", len(contactIDs))
-	return contactIDs, nil
+// Booking describes what a successful preserve booked. OrderID is the ID of
+// the created order if the preserve service reported it, releases that do
+// not report it need LocateOrder.
+type Booking struct {
+	OrderID    string
+	TripID     string
+	ContactsID string
+	// ContactsDocument is the document number of the contact, if known
+	ContactsDocument string
+	SeatType         string
+	Date             string
+	From             string
+	To               string
+	HighSpeed        bool
+	// Requested is when the preserve request was sent
+	Requested time.Time
+	// Plain books without food and assurance, skipping their lookups
+	Plain bool
 }
 
-func (q *Query) Preserve(start, end string, tripIDs []string, isHighSpeed bool, date string) error {
+// Preserve books one of tripIDs for q.Contact, or else for a random contact
+// of the account
+func (q *Query) Preserve(start, end string, tripIDs []string, isHighSpeed bool, date string) (*Booking, error) {
 	if len(tripIDs) == 0 {
-		return fmt.Errorf("no trips available for preservation")
+		return nil, fmt.Errorf("no trips available for preservation")
 	}
 
-	var url string
-	if isHighSpeed {
-		url = fmt.Sprintf("%s/api/v1/preserveservice/preserve", q.Address)
+	var contact Contact
+	if q.Contact != nil {
+		contact = *q.Contact
 	} else {
-		url = fmt.Sprintf("%s/api/v1/preserveotherservice/preserveOther", q.Address)
+		contacts, err := q.QueryContacts()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query contacts: %v", err)
+		}
+
+		if len(contacts) == 0 {
+			return nil, fmt.Errorf("no contacts found")
+		}
+
+		contact = contacts[q.Rand.Intn(len(contacts))]
 	}
 
-	contacts_result, err := q.QueryContacts()
+	booking, err := q.PreserveFor(contact.ID, start, end, tripIDs, isHighSpeed, date)
 	if err != nil {
-		return fmt.Errorf("failed to query contacts: %v", err)
+		return nil, err
 	}
+	booking.ContactsDocument = contact.DocumentNumber
+	return booking, nil
+}
 
-	if len(contacts_result) == 0 {
-		return fmt.Errorf("no contacts found")
-	}
+// PreserveFor books one of tripIDs for the given contact
+func (q *Query) PreserveFor(contactsId, start, end string, tripIDs []string, isHighSpeed bool, date string) (*Booking, error) {
+	if len(tripIDs) == 0 {
+		return nil, fmt.Errorf("no trips available for preservation")
+	}
+
+	return q.PreserveBooking(Booking{
+		TripID:     RandomFromList(q.Rand, tripIDs).(string),
+		ContactsID: contactsId,
+		SeatType:   RandomFromList(q.Rand, []string{"2", "3"}).(string),
+		Date:       date,
+		From:       start,
+		To:         end,
+		HighSpeed:  isHighSpeed,
+	})
+}
 
-	contactsId := RandomFromList(contacts_result).(string)
+// PreserveBooking books exactly the trip, contact, seat type and date of b,
+// with random food and assurance unless b is plain. The returned booking
+// carries the order ID if the service reported it.
+func (q *Query) PreserveBooking(b Booking) (*Booking, error) {
+	var url string
+	if b.HighSpeed {
+		url = fmt.Sprintf("%s/api/v1/preserveservice/preserve", q.Address)
+	} else {
+		url = fmt.Sprintf("%s/api/v1/preserveotherservice/preserveOther", q.Address)
+	}
 
 	payload := map[string]interface{}{
 		"accountId":  q.UID,
-		"contactsId": contactsId,
-		"tripId":     RandomFromList(tripIDs).(string),
-		"seatType":   RandomFromList([]string{"2", "3"}),
-		"date":       date,
-		"from":       start,
-		"to":         end,
+		"contactsId": b.ContactsID,
+		"tripId":     b.TripID,
+		"seatType":   b.SeatType,
+		"date":       b.Date,
+		"from":       b.From,
+		"to":         b.To,
 		"assurance":  "0",
 		"foodType":   "0",
 	}
 
+	// Like the Python client, attach food and assurance half of the time so
+	// the food and assurance services get exercised by preserve
+	needFood := !b.Plain && RandomBoolean(q.Rand)
+	if needFood {
+		foods, err := q.QueryFood(b.Date, [2]string{b.From, b.To}, b.TripID)
+		if err != nil {
+			log.Printf("Preserving without food: %v", err)
+		} else if len(foods) > 0 {
+			food := foods[q.Rand.Intn(len(foods))]
+			payload["foodType"] = food.FoodType
+			payload["foodName"] = food.FoodName
+			payload["foodPrice"] = food.FoodPrice
+			if food.FoodType == FoodTypeStation {
+				payload["stationName"] = food.StationName
+				payload["storeName"] = food.StoreName
+			}
+		}
+	}
+
+	needAssurance := !b.Plain && RandomBoolean(q.Rand)
+	if needAssurance {
+		assurances, err := q.QueryAssurances()
+		if err != nil {
+			log.Printf("Preserving without assurance: %v", err)
+		} else if len(assurances) > 0 {
+			payload["assurance"] = assurances[q.Rand.Intn(len(assurances))].Index
+		}
+	}
+
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %v", err)
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+q.Token)
 
 	log.Printf("Sending preserve request to %s with payload: %s", url, string(jsonPayload))
+	b.Requested = time.Now()
 
 	resp, err := q.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("preserve request failed: %v", err)
+		return nil, fmt.Errorf("preserve request failed: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -673,68 +1022,180 @@ func (q *Query) Preserve(start, end string, tripIDs []string, isHighSpeed bool,
 
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse preserve response: %v", err)
+		return nil, fmt.Errorf("failed to parse preserve response: %v", err)
 	}
 
 	if status, ok := result["status"].(float64); !ok || status != 1 {
-		return fmt.Errorf("preserve failed: %s", result["msg"])
+		return nil, fmt.Errorf("preserve failed: %s", result["msg"])
 	}
 
-	return nil
+	b.OrderID = preserveOrderID(result)
+	return &b, nil
 }
 
-func (q *Query) PutConsign(result map[string]interface{}) error {
-	url := fmt.Sprintf("%s/api/v1/consignservice/consigns", q.Address)
+var uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// preserveOrderID extracts the ID of the created order from a preserve
+// response, which carries it as an order object or in the success message
+// depending on the release. It returns "" if the response has none.
+func preserveOrderID(result map[string]interface{}) string {
+	switch data := result["data"].(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"id", "orderId"} {
+			if id, ok := data[key].(string); ok && id != "" {
+				return id
+			}
+		}
+	case string:
+		if id := uuidPattern.FindString(data); id != "" {
+			return id
+		}
+	}
+
+	msg, _ := result["msg"].(string)
+	return uuidPattern.FindString(msg)
+}
+
+// Consign is a consignment request of ts-consign-service, and the record it
+// returns when queried (which adds the price)
+type Consign struct {
+	ID         string      `json:"id"`
+	OrderID    string      `json:"orderId"`
+	AccountID  string      `json:"accountId"`
+	HandleDate looseString `json:"handleDate"`
+	TargetDate looseString `json:"targetDate"`
+	From       string      `json:"from"`
+	To         string      `json:"to"`
+	Consignee  string      `json:"consignee"`
+	Phone      string      `json:"phone"`
+	Weight     float64     `json:"weight"`
+	IsWithin   bool        `json:"isWithin"`
+	Price      float64     `json:"price,omitempty"`
+}
+
+// NewConsign returns a consignment of the given order with a random
+// consignee, phone and weight
+func NewConsign(r *rand.Rand, accountID, orderID, from, to string) Consign {
+	c := Consign{
+		OrderID:    orderID,
+		AccountID:  accountID,
+		HandleDate: looseString(time.Now().Format("2006-01-02")),
+		TargetDate: looseString(time.Now().Format("2006-01-02 15:04:05")),
+		From:       from,
+		To:         to,
+	}
+	c.Randomize(r)
+	return c
+}
 
+// Randomize draws a new consignee, phone, weight and within-region flag
+func (c *Consign) Randomize(r *rand.Rand) {
+	c.Consignee = RandomString(r, 4+r.Intn(8))
+	c.Phone = RandomPhone(r)
+	c.Weight = float64(1 + r.Intn(50))
+	c.IsWithin = RandomBoolean(r)
+}
+
+func (q *Query) PutConsign(result map[string]interface{}) error {
 	// Ensure all required fields are present
 	requiredFields := []string{"accountId", "orderId", "from", "to"}
 	for _, field := range requiredFields {
-		if _, ok := result[field]; !ok {
+		if _, ok := result[field].(string); !ok {
 			return fmt.Errorf("missing required field: %s", field)
 		}
 	}
 
-	consignload := map[string]interface{}{
-		"accountId":  result["accountId"],
-		"handleDate": time.Now().Format("2006-01-02"),
-		"targetDate": time.Now().Format("2006-01-02 15:04:05"),
-		"from":       result["from"],
-		"to":         result["to"],
-		"orderId":    result["orderId"],
-		"consignee":  "32",
-		"phone":      "12345677654",
-		"weight":     "32",
-		"id":         "",
-		"isWithin":   false,
+	c := NewConsign(q.Rand, result["accountId"].(string), result["orderId"].(string),
+		result["from"].(string), result["to"].(string))
+
+	// Without an ID the update endpoint inserts a new consignment
+	if err := q.UpdateConsign(c); err != nil {
+		return err
 	}
 
-	jsonPayload, err := json.Marshal(consignload)
+	log.Printf("Consignment for order %s put successfully", c.OrderID)
+	return nil
+}
+
+// InsertConsign creates a consignment
+func (q *Query) InsertConsign(c Consign) error {
+	url := fmt.Sprintf("%s/api/v1/consignservice/consigns", q.Address)
+
+	resp, err := q.doRequest("POST", url, c, nil)
 	if err != nil {
-		return fmt.Errorf("failed to marshal consign payload: %v", err)
+		return fmt.Errorf("insert consign failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return fmt.Errorf("insert consign failed: %s", resp.Msg)
 	}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonPayload))
+	return nil
+}
+
+// UpdateConsign modifies the consignment with c.ID, or inserts c if no
+// consignment has that ID
+func (q *Query) UpdateConsign(c Consign) error {
+	url := fmt.Sprintf("%s/api/v1/consignservice/consigns", q.Address)
+
+	resp, err := q.doRequest("PUT", url, c, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create consign request: %v", err)
+		return fmt.Errorf("update consign failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return fmt.Errorf("update consign failed: %s", resp.Msg)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+q.Token)
+	return nil
+}
 
-	resp, err := q.Client.Do(req)
+// QueryConsignsByAccount lists the consignments of an account
+func (q *Query) QueryConsignsByAccount(accountID string) ([]Consign, error) {
+	url := fmt.Sprintf("%s/api/v1/consignservice/consigns/account/%s", q.Address, accountID)
+
+	var consigns []Consign
+	resp, err := q.doRequest("GET", url, nil, &consigns)
 	if err != nil {
-		return fmt.Errorf("failed to send consign request: %v", err)
+		return nil, fmt.Errorf("query consigns by account failed: %v", err)
+	}
+	if resp.Status != 1 {
+		if noConsigns(resp.Msg) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query consigns by account failed: %s", resp.Msg)
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	return consigns, nil
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("put consign failed with status code: %d, body: %s", resp.StatusCode, string(body))
+// noConsigns reports whether the message of a status 0 consign response
+// says there is nothing to find ("No Content according to accountId"),
+// rather than that the query failed
+func noConsigns(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "no content") || strings.Contains(msg, "no consign")
+}
+
+// QueryConsignByOrder returns the consignment of an order, or nil if the
+// order has none
+func (q *Query) QueryConsignByOrder(orderID string) (*Consign, error) {
+	url := fmt.Sprintf("%s/api/v1/consignservice/consigns/order/%s", q.Address, orderID)
+
+	var consign *Consign
+	resp, err := q.doRequest("GET", url, nil, &consign)
+	if err != nil {
+		return nil, fmt.Errorf("query consign by order failed: %v", err)
+	}
+	if resp.Status != 1 {
+		if noConsigns(resp.Msg) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("query consign by order failed: %s", resp.Msg)
 	}
 
-	log.Printf("Consignment for order %s put successfully", result["orderId"])
-	return nil
+	if consign != nil && consign.ID == "" {
+		return nil, nil
+	}
+	return consign, nil
 }
 
 func (q *Query) PayOrder(orderID, tripID string) error {
@@ -774,46 +1235,57 @@ func (q *Query) PayOrder(orderID, tripID string) error {
 	return nil
 }
 
-func (q *Query) RebookTicket(oldOrderID, oldTripID, newTripID, newDate, newSeatType string) error {
-	url := fmt.Sprintf("%s/api/v1/rebookservice/rebook", q.Address)
+// Statuses of a rebook response. RebookDifferenceDue means the new trip
+// costs more and the rebook only happens once PayRebookDifference succeeds.
+const (
+	RebookDone          = 1
+	RebookDifferenceDue = 2
+)
 
-	payload := map[string]string{
+// RebookResult is the answer of the rebook service. Status is RebookDone,
+// RebookDifferenceDue or anything else for a rejection explained by Msg.
+type RebookResult struct {
+	Status int
+	Msg    string
+}
+
+func rebookPayload(q *Query, oldOrderID, oldTripID, newTripID, newDate, newSeatType string) map[string]string {
+	return map[string]string{
+		"loginId":   q.UID,
 		"oldTripId": oldTripID,
 		"orderId":   oldOrderID,
 		"tripId":    newTripID,
 		"date":      newDate,
 		"seatType":  newSeatType,
 	}
+}
 
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal rebook payload: %v", err)
-	}
+// RebookTicket asks the rebook service to move an order to another trip or
+// date. The error only reports failed requests, rejections are in the result.
+func (q *Query) RebookTicket(oldOrderID, oldTripID, newTripID, newDate, newSeatType string) (*RebookResult, error) {
+	url := fmt.Sprintf("%s/api/v1/rebookservice/rebook", q.Address)
+	payload := rebookPayload(q, oldOrderID, oldTripID, newTripID, newDate, newSeatType)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	resp, err := q.doRequest("POST", url, payload, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create rebook request: %v", err)
+		return nil, fmt.Errorf("rebook request failed: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+q.Token)
-
-	resp, err := q.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send rebook request: %v", err)
-	}
-	defer resp.Body.Close()
+	return &RebookResult{Status: resp.Status, Msg: resp.Msg}, nil
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	// log.Printf("Rebook response: %s", string(body))
+// PayRebookDifference pays the price difference of a rebook that returned
+// RebookDifferenceDue, which completes that rebook
+func (q *Query) PayRebookDifference(oldOrderID, oldTripID, newTripID, newDate, newSeatType string) error {
+	url := fmt.Sprintf("%s/api/v1/rebookservice/rebook/difference", q.Address)
+	payload := rebookPayload(q, oldOrderID, oldTripID, newTripID, newDate, newSeatType)
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse rebook response: %v", err)
+	resp, err := q.doRequest("POST", url, payload, nil)
+	if err != nil {
+		return fmt.Errorf("pay rebook difference failed: %v", err)
 	}
-
-	if status, ok := result["status"].(float64); !ok || status != 1 {
-		return fmt.Errorf("rebook failed: %s", result["msg"])
+	if resp.Status != 1 {
+		return fmt.Errorf("pay rebook difference failed: %s", resp.Msg)
 	}
 
 	return nil
@@ -932,10 +1404,17 @@ func (q *Query) QueryOrdersAllInfo(queryOther bool) ([]map[string]interface{}, e
 
 	_ = OCManager.QuerySem.Acquire(context.Background(), 1)
 
+	cache := OCManager.OrdersCache
 	if queryOther {
-		toReturn = OCManager.OrdersCacheOther
-	} else {
-		toReturn = OCManager.OrdersCache
+		cache = OCManager.OrdersCacheOther
+	}
+
+	// Orders claimed by a journey are left to the journey that follows them
+	for _, order := range cache {
+		if id, ok := order["orderId"].(string); ok && orderClaimed(id) {
+			continue
+		}
+		toReturn = append(toReturn, order)
 	}
 
 	OCManager.QuerySem.Release(1)
@@ -943,38 +1422,57 @@ func (q *Query) QueryOrdersAllInfo(queryOther bool) ([]map[string]interface{}, e
 	return toReturn, nil
 }
 
-func (q *Query) QueryAdminBasicPrice() (*http.Response, error) {
+type PriceConfig struct {
+	ID                  string  `json:"id"`
+	TrainType           string  `json:"trainType"`
+	RouteID             string  `json:"routeId"`
+	BasicPriceRate      float64 `json:"basicPriceRate"`
+	FirstClassPriceRate float64 `json:"firstClassPriceRate"`
+}
+
+type ConfigEntry struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+type AdminTrip struct {
+	Trip  Trip  `json:"trip"`
+	Route Route `json:"route"`
+}
+
+func (q *Query) QueryAdminBasicPrice() ([]PriceConfig, error) {
 	url := fmt.Sprintf("%s/api/v1/adminbasicservice/adminbasic/prices", q.Address)
 
-	resp, err := q.Client.Get(url)
+	var prices []PriceConfig
+	resp, err := q.doRequest("GET", url, nil, &prices)
 	if err != nil {
-		return nil, err
+		log.Printf("Query price failed: %v", err)
+		return nil, fmt.Errorf("query price failed: %v", err)
 	}
-
-	if resp.StatusCode == http.StatusOK {
-		log.Println("Query price success")
-		return resp, nil
-	} else {
-		log.Printf("Query price failed with status code: %d", resp.StatusCode)
-		return nil, fmt.Errorf("query price failed")
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("query price failed: %s", resp.Msg)
 	}
+
+	log.Println("Query price success")
+	return prices, nil
 }
 
-func (q *Query) QueryAdminBasicConfig() (*http.Response, error) {
+func (q *Query) QueryAdminBasicConfig() ([]ConfigEntry, error) {
 	url := fmt.Sprintf("%s/api/v1/adminbasicservice/adminbasic/configs", q.Address)
 
-	resp, err := q.Client.Get(url)
+	var configs []ConfigEntry
+	resp, err := q.doRequest("GET", url, nil, &configs)
 	if err != nil {
-		return nil, err
+		log.Printf("Config failed: %v", err)
+		return nil, fmt.Errorf("config failed: %v", err)
 	}
-
-	if resp.StatusCode == http.StatusOK {
-		log.Println("Config success")
-		return resp, nil
-	} else {
-		log.Printf("Config failed with status code: %d", resp.StatusCode)
-		return nil, fmt.Errorf("config failed")
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("config failed: %s", resp.Msg)
 	}
+
+	log.Println("Config success")
+	return configs, nil
 }
 
 func (q *Query) QueryRoute(routeId string) error {
@@ -985,40 +1483,379 @@ func (q *Query) QueryRoute(routeId string) error {
 		url = fmt.Sprintf("%s/api/v1/routeservice/routes/%s", q.Address, routeId)
 	}
 
-	resp, err := q.Client.Get(url)
+	resp, err := q.doRequest("GET", url, nil, nil)
 	if err != nil {
-		return err
+		log.Printf("Query routeId: %s fail: %v", routeId, err)
+		return fmt.Errorf("query route failed: %v", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Query routeId success")
-	} else {
-		log.Printf("Query routeId: %s fail, code: %d, text: %s", routeId, resp.StatusCode, resp.Status)
-		return fmt.Errorf("query route failed")
+	if resp.Status != 1 {
+		return fmt.Errorf("query route failed: %s", resp.Msg)
 	}
 
+	log.Printf("Query routeId success")
 	return nil
 }
 
-func (q *Query) QueryAdminTravel() error {
+func (q *Query) QueryAdminTravel() ([]AdminTrip, error) {
 	url := fmt.Sprintf("%s/api/v1/admintravelservice/admintravel", q.Address)
 
-	resp, err := q.Client.Get(url)
+	var trips []AdminTrip
+	resp, err := q.doRequest("GET", url, nil, &trips)
 	if err != nil {
-		return err
+		log.Printf("Failed to query admin travel: %v", err)
+		return nil, fmt.Errorf("query admin travel failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("query admin travel failed: %s", resp.Msg)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		var result map[string]interface{}
-		json.NewDecoder(resp.Body).Decode(&result)
-		if result["status"] == float64(1) {
-			log.Println("Success to query admin travel")
-			return nil
+	log.Println("Success to query admin travel")
+	return trips, nil
+}
+
+type Route struct {
+	ID        string   `json:"id"`
+	Stations  []string `json:"stations"`
+	Distances []int    `json:"distances"`
+}
+
+type Station struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	StayTime int    `json:"stayTime"`
+}
+
+type TripID struct {
+	Type   string `json:"type"`
+	Number string `json:"number"`
+}
+
+func (t TripID) String() string {
+	return t.Type + t.Number
+}
+
+type Trip struct {
+	TripID  TripID `json:"tripId"`
+	RouteID string `json:"routeId"`
+}
+
+// QueryRoutes lists every route known to ts-route-service
+func (q *Query) QueryRoutes() ([]Route, error) {
+	url := fmt.Sprintf("%s/api/v1/routeservice/routes", q.Address)
+
+	var routes []Route
+	if _, err := q.doRequest("GET", url, nil, &routes); err != nil {
+		return nil, fmt.Errorf("query routes failed: %v", err)
+	}
+
+	return routes, nil
+}
+
+// QueryStations lists every station known to ts-station-service
+func (q *Query) QueryStations() ([]Station, error) {
+	url := fmt.Sprintf("%s/api/v1/stationservice/stations", q.Address)
+
+	var stations []Station
+	if _, err := q.doRequest("GET", url, nil, &stations); err != nil {
+		return nil, fmt.Errorf("query stations failed: %v", err)
+	}
+
+	return stations, nil
+}
+
+// QueryTrips lists the trips of the high-speed (travel) or normal (travel2) service
+func (q *Query) QueryTrips(isHighSpeed bool) ([]Trip, error) {
+	var url string
+	if isHighSpeed {
+		url = fmt.Sprintf("%s/api/v1/travelservice/trips", q.Address)
+	} else {
+		url = fmt.Sprintf("%s/api/v1/travel2service/trips", q.Address)
+	}
+
+	var trips []Trip
+	if _, err := q.doRequest("GET", url, nil, &trips); err != nil {
+		return nil, fmt.Errorf("query trips failed: %v", err)
+	}
+
+	return trips, nil
+}
+
+// Order statuses of the order services
+const (
+	OrderNotPaid = iota
+	OrderPaid
+	OrderCollected
+	OrderChange
+	OrderCancel
+	OrderRefunds
+	OrderUsed
+)
+
+var orderStatusNames = []string{"NOTPAID", "PAID", "COLLECTED", "CHANGE", "CANCEL", "REFUNDS", "USED"}
+
+// OrderStatusName returns the name the order services give a status
+func OrderStatusName(status int) string {
+	if status < 0 || status >= len(orderStatusNames) {
+		return strconv.Itoa(status)
+	}
+	return orderStatusNames[status]
+}
+
+// Order is an order as returned by the order refresh endpoints
+type Order struct {
+	ID                     string      `json:"id"`
+	AccountID              string      `json:"accountId"`
+	TrainNumber            string      `json:"trainNumber"`
+	From                   string      `json:"from"`
+	To                     string      `json:"to"`
+	Status                 int         `json:"status"`
+	SeatClass              int         `json:"seatClass"`
+	SeatNumber             looseString `json:"seatNumber"`
+	Price                  looseString `json:"price"`
+	TravelDate             looseString `json:"travelDate"`
+	BoughtDate             looseString `json:"boughtDate"`
+	ContactsName           string      `json:"contactsName"`
+	DocumentType           int         `json:"documentType"`
+	ContactsDocumentNumber string      `json:"contactsDocumentNumber"`
+}
+
+// TravelDay reports whether the order travels on day (YYYY-MM-DD). Older
+// releases serialize the travel date as epoch milliseconds, which are
+// compared in both UTC and local time since the services may run in either.
+func (o Order) TravelDay(day string) bool {
+	value := string(o.TravelDate)
+	if strings.HasPrefix(value, day) {
+		return true
+	}
+
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	t := time.UnixMilli(millis)
+	return t.UTC().Format("2006-01-02") == day || t.Format("2006-01-02") == day
+}
+
+// Day returns the travel day of the order as YYYY-MM-DD, reading epoch
+// milliseconds in UTC
+func (o Order) Day() string {
+	value := string(o.TravelDate)
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis).UTC().Format("2006-01-02")
+	}
+	if len(value) >= 10 {
+		return value[:10]
+	}
+	return value
+}
+
+// RefreshOrders lists the orders of the logged in account from the order
+// service, or the order-other service if other is set
+func (q *Query) RefreshOrders(other bool) ([]Order, error) {
+	var url string
+	if other {
+		url = fmt.Sprintf("%s/api/v1/orderOtherService/orderOther/refresh", q.Address)
+	} else {
+		url = fmt.Sprintf("%s/api/v1/orderservice/order/refresh", q.Address)
+	}
+
+	var orders []Order
+	resp, err := q.doRequest("POST", url, map[string]string{"loginId": q.UID}, &orders)
+	if err != nil {
+		return nil, fmt.Errorf("refresh orders failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return nil, fmt.Errorf("refresh orders failed: %s", resp.Msg)
+	}
+
+	return orders, nil
+}
+
+// GetOrder reads one order from the order service, or the order-other
+// service if other is set
+func (q *Query) GetOrder(orderID string, other bool) (*Order, error) {
+	var url string
+	if other {
+		url = fmt.Sprintf("%s/api/v1/orderOtherService/orderOther/%s", q.Address, orderID)
+	} else {
+		url = fmt.Sprintf("%s/api/v1/orderservice/order/%s", q.Address, orderID)
+	}
+
+	var order *Order
+	resp, err := q.doRequest("GET", url, nil, &order)
+	if err != nil {
+		return nil, fmt.Errorf("get order failed: %v", err)
+	}
+	if resp.Status != 1 || order == nil {
+		return nil, fmt.Errorf("get order %s failed: %s", orderID, resp.Msg)
+	}
+
+	return order, nil
+}
+
+// orderClaims maps the IDs of located orders to the booking they were
+// resolved to. All workers book with the same account, so LocateOrder never
+// resolves a booking to an order another booking already claimed.
+var orderClaims = struct {
+	sync.Mutex
+	owners map[string]*Booking
+}{owners: make(map[string]*Booking)}
+
+// claimOrder claims an order for b and reports false if another booking
+// already claimed it
+func claimOrder(id string, b *Booking) bool {
+	orderClaims.Lock()
+	defer orderClaims.Unlock()
+
+	if owner, ok := orderClaims.owners[id]; ok && owner != b {
+		return false
+	}
+	orderClaims.owners[id] = b
+	return true
+}
+
+// unclaimOrder releases the claim of b on an order, so the order cache hands
+// it out again
+func unclaimOrder(id string, b *Booking) {
+	orderClaims.Lock()
+	defer orderClaims.Unlock()
+
+	if orderClaims.owners[id] == b {
+		delete(orderClaims.owners, id)
+	}
+}
+
+func orderClaimed(id string) bool {
+	orderClaims.Lock()
+	defer orderClaims.Unlock()
+
+	_, ok := orderClaims.owners[id]
+	return ok
+}
+
+// LocateOrder finds the order a booking created and claims it. If the
+// preserve service reported its ID the order is read directly. Otherwise the
+// candidates are the unclaimed unpaid orders of the same trip, day, seat
+// class and contact bought since the booking was requested. Anything but
+// exactly one candidate is an error rather than a guess. The booking keeps
+// the ID, so locating it again reads the claimed order directly.
+func (q *Query) LocateOrder(b *Booking) (*Order, error) {
+	if b.OrderID != "" {
+		order, err := q.GetOrder(b.OrderID, !b.HighSpeed)
+		if err != nil {
+			return nil, err
+		}
+		if !claimOrder(order.ID, b) {
+			return nil, fmt.Errorf("order %s is already claimed by another booking", order.ID)
+		}
+		return order, nil
+	}
+
+	if b.ContactsDocument == "" {
+		contacts, err := q.QueryContacts()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query contacts: %v", err)
+		}
+		for _, c := range contacts {
+			if c.ID == b.ContactsID {
+				b.ContactsDocument = c.DocumentNumber
+			}
+		}
+		if b.ContactsDocument == "" {
+			return nil, fmt.Errorf("contact %s of the booking not found", b.ContactsID)
+		}
+	}
+
+	orders, err := q.RefreshOrders(!b.HighSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*Order
+	for i := range orders {
+		o := &orders[i]
+		if o.Status != OrderNotPaid || o.TrainNumber != b.TripID || !o.TravelDay(b.Date) {
+			continue
+		}
+		if strconv.Itoa(o.SeatClass) != b.SeatType || o.ContactsDocumentNumber != b.ContactsDocument {
+			continue
+		}
+		if !b.Requested.IsZero() && !o.BoughtSince(b.Requested) {
+			continue
+		}
+		if orderClaimed(o.ID) {
+			continue
 		}
+		candidates = append(candidates, o)
 	}
 
-	log.Printf("Failed to query admin travel with status code: %d", resp.StatusCode)
-	return fmt.Errorf("query admin travel failed")
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("no unclaimed unpaid order of trip %s on %s found among %d orders", b.TripID, b.Date, len(orders))
+	case 1:
+	default:
+		ambiguous := &AmbiguousOrderError{Booking: b}
+		for _, o := range candidates {
+			ambiguous.Candidates = append(ambiguous.Candidates, *o)
+		}
+		return nil, ambiguous
+	}
+
+	if !claimOrder(candidates[0].ID, b) {
+		return nil, fmt.Errorf("order %s was claimed by another booking meanwhile", candidates[0].ID)
+	}
+	b.OrderID = candidates[0].ID
+
+	return candidates[0], nil
+}
+
+// AmbiguousOrderError is the error of LocateOrder when several unclaimed
+// orders match a booking
+type AmbiguousOrderError struct {
+	Booking    *Booking
+	Candidates []Order
+}
+
+func (e *AmbiguousOrderError) Error() string {
+	return fmt.Sprintf("%d unclaimed unpaid orders of trip %s on %s match the booking", len(e.Candidates), e.Booking.TripID, e.Booking.Date)
+}
+
+// BoughtSince reports whether the order may have been bought at or after t.
+// The bought date is epoch milliseconds or a "2006-01-02 15:04:05" string in
+// the time zone of the services, which is read as both UTC and local time.
+// A minute of tolerance absorbs clock skew, unreadable dates always match.
+func (o Order) BoughtSince(t time.Time) bool {
+	t = t.Add(-time.Minute)
+	value := string(o.BoughtDate)
+
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return !time.UnixMilli(millis).Before(t)
+	}
+
+	utc, err := time.Parse("2006-01-02 15:04:05", value)
+	if err != nil {
+		return true
+	}
+	local, _ := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	return !utc.Before(t) || !local.Before(t)
+}
+
+// AdminDeleteOrder deletes an order of any account through the admin order
+// service. The train number selects the order or order-other service.
+func (q *Query) AdminDeleteOrder(orderID, trainNumber string) error {
+	admin, err := q.AdminQuery()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/adminorderservice/adminorder/%s/%s", q.Address, orderID, trainNumber)
+	resp, err := admin.doRequest("DELETE", url, nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete order failed: %v", err)
+	}
+	if resp.Status != 1 {
+		return fmt.Errorf("delete order failed: %s", resp.Msg)
+	}
+
+	return nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RunReport is the JSON summary of a load test written with -report
+type RunReport struct {
+	URL       string       `json:"url"`
+	Seed      int64        `json:"seed"`
+	Threads   int          `json:"threads"`
+	Duration  int          `json:"durationSeconds"`
+	Scenarios []string     `json:"scenarios,omitempty"`
+	Started   time.Time    `json:"started"`
+	Finished  time.Time    `json:"finished"`
+	Fault     *FaultChoice `json:"fault,omitempty"`
+	// Statistics is the statistics report of the run as logged
+	Statistics string `json:"statistics"`
+}
+
+func (r *RunReport) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run report: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write run report: %v", err)
+	}
+	return nil
+}
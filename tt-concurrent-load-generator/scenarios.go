@@ -1,19 +1,190 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// highspeedWeights is the relative share of high-speed (true) and normal
+// train (false) bookings and orders, in scenarios and warmup alike
 var highspeedWeights = map[bool]int{true: 60, false: 40}
 
+// ParseHighSpeedWeights parses "<highspeed>:<normal>", such as "60:40"
+func ParseHighSpeedWeights(spec string) (map[bool]int, error) {
+	hsStr, normalStr, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("expected <highspeed>:<normal>, got %q", spec)
+	}
+
+	hs, err1 := strconv.Atoi(strings.TrimSpace(hsStr))
+	normal, err2 := strconv.Atoi(strings.TrimSpace(normalStr))
+	if err1 != nil || err2 != nil || hs < 0 || normal < 0 || hs+normal == 0 {
+		return nil, fmt.Errorf("invalid weights %q", spec)
+	}
+
+	return map[bool]int{true: hs, false: normal}, nil
+}
+
+const (
+	FamilyUser  = "user"
+	FamilyAdmin = "admin"
+)
+
+// Scenario is one workload a worker can run. Weight is its relative share
+// of the scenarios a worker picks.
+type Scenario struct {
+	Name     string
+	Function func(*Query)
+	Family   string
+	Weight   float64
+}
+
+// AllScenarios lists every scenario, in the order of the scenario bitmap
+var AllScenarios = []Scenario{
+	{Name: "QueryAndPreserve", Function: QueryAndPreserve, Family: FamilyUser},
+	{Name: "QueryAndPay", Function: QueryAndPay, Family: FamilyUser},
+	{Name: "QueryAndCancel", Function: QueryAndCancel, Family: FamilyUser},
+	{Name: "QueryAndCollect", Function: QueryAndCollect, Family: FamilyUser},
+	{Name: "QueryAndExecute", Function: QueryAndExecute, Family: FamilyUser},
+	{Name: "QueryAndConsign", Function: QueryAndConsign, Family: FamilyUser},
+	{Name: "QueryAndRebook", Function: QueryAndRebook, Family: FamilyUser},
+	{Name: "QueryOnlyHighSpeed", Function: QueryOnlyHighSpeed, Family: FamilyUser},
+	{Name: "QueryTravelPlan", Function: QueryTravelPlan, Family: FamilyUser},
+	{Name: "QueryOnlyHighSpeedParallel", Function: QueryOnlyHighSpeedParallel, Family: FamilyUser},
+	{Name: "CompareHighSpeedSearch", Function: CompareHighSpeedSearch, Family: FamilyUser},
+	{Name: "AdminQueryPrices", Function: AdminQueryPrices, Family: FamilyAdmin},
+	{Name: "AdminQueryConfigs", Function: AdminQueryConfigs, Family: FamilyAdmin},
+	{Name: "AdminQueryTravel", Function: AdminQueryTravel, Family: FamilyAdmin},
+	{Name: "AdminQueryRoutes", Function: AdminQueryRoutes, Family: FamilyAdmin},
+	{Name: "AdminBrowse", Function: AdminBrowse, Family: FamilyAdmin},
+	{Name: "FullJourney", Function: FullJourney, Family: FamilyUser},
+	{Name: "ConsignLifecycle", Function: ConsignLifecycle, Family: FamilyUser},
+	{Name: "AddContactAndBook", Function: AddContactAndBook, Family: FamilyUser},
+}
+
+// DefaultScenarios is the workload without SCENARIO_FLAGS or -scenarios: the
+// original eight scenarios with equal weights. Later scenarios are opt-in,
+// so existing experiment invocations keep their load mix.
+const DefaultScenarios = "QueryAndPreserve,QueryAndPay,QueryAndCancel,QueryAndCollect," +
+	"QueryAndExecute,QueryAndConsign,QueryAndRebook,QueryOnlyHighSpeed"
+
+// SelectScenarios parses a spec of the form "name[=weight],..." where name
+// is a scenario or a family ("user", "admin", "custom"). A family's weight is
+// split among its scenarios by their default weights, so "user=4,admin=1" sends a fifth of the
+// scenarios to the admin family. Scenario entries override family entries.
+func SelectScenarios(spec string) ([]Scenario, error) {
+	familyWeights := make(map[string]float64)
+	scenarioWeights := make(map[string]float64)
+
+	for _, token := range strings.Split(spec, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		name, weightStr, hasWeight := strings.Cut(token, "=")
+		weight := 1.0
+		if hasWeight {
+			w, err := strconv.ParseFloat(weightStr, 64)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", token)
+			}
+			weight = w
+		}
+
+		if findScenario(name) != nil {
+			scenarioWeights[name] = weight
+		} else if len(familyScenarios(name)) > 0 {
+			familyWeights[name] = weight
+		} else {
+			return nil, fmt.Errorf("unknown scenario or family %q", name)
+		}
+	}
+
+	selected := make([]Scenario, 0)
+	for _, scenario := range AllScenarios {
+		weight, ok := scenarioWeights[scenario.Name]
+		if !ok {
+			familyWeight, ok := familyWeights[scenario.Family]
+			if !ok {
+				continue
+			}
+			weight = familyWeight * scenario.defaultWeight() / familyTotalWeight(scenario.Family)
+		}
+
+		if weight > 0 {
+			scenario.Weight = weight
+			selected = append(selected, scenario)
+		}
+	}
+
+	return selected, nil
+}
+
+func findScenario(name string) *Scenario {
+	for i := range AllScenarios {
+		if AllScenarios[i].Name == name {
+			return &AllScenarios[i]
+		}
+	}
+	return nil
+}
+
+// defaultWeight is the weight of a scenario within its family, 1 unless the
+// scenario definition says otherwise
+func (s Scenario) defaultWeight() float64 {
+	if s.Weight > 0 {
+		return s.Weight
+	}
+	return 1
+}
+
+func familyTotalWeight(family string) float64 {
+	total := 0.0
+	for _, scenario := range familyScenarios(family) {
+		total += scenario.defaultWeight()
+	}
+	return total
+}
+
+func familyScenarios(family string) []Scenario {
+	members := make([]Scenario, 0)
+	for _, scenario := range AllScenarios {
+		if scenario.Family == family {
+			members = append(members, scenario)
+		}
+	}
+	return members
+}
+
+// PickScenario draws a scenario according to the scenario weights
+func PickScenario(r *rand.Rand, scenarios []Scenario) Scenario {
+	total := 0.0
+	for _, scenario := range scenarios {
+		total += scenario.Weight
+	}
+
+	n := r.Float64() * total
+	for _, scenario := range scenarios {
+		n -= scenario.Weight
+		if n < 0 {
+			return scenario
+		}
+	}
+
+	return scenarios[len(scenarios)-1]
+}
+
 func QueryAndCancel(q *Query) {
 	log.Println("Starting QueryAndCancel operation")
 	pairs := make([][2]string, 0)
 	var err error
 
-	//rfw := RandomFromWeighted(highspeedWeights)
+	//rfw := RandomFromWeighted(q.Rand, highspeedWeights)
 
 	//if len(pairs) == 0 {
 	//    log.Println("No orders found for cancellation")
@@ -22,7 +193,7 @@ func QueryAndCancel(q *Query) {
 
 	for len(pairs) == 0 {
 		//if rfw {
-		if RandomFromWeighted(highspeedWeights) {
+		if RandomFromWeighted(q.Rand, highspeedWeights) {
 			log.Println("Querying high-speed orders")
 			pairs, err = q.QueryOrders([]int{0, 1}, false)
 		} else {
@@ -36,7 +207,7 @@ func QueryAndCancel(q *Query) {
 		}
 	}
 
-	pair := RandomFromList(pairs).([2]string)
+	pair := RandomFromList(q.Rand, pairs).([2]string)
 	orderID := pair[0]
 
 	log.Printf("Selected order %s for cancellation", orderID)
@@ -55,7 +226,7 @@ func QueryAndCollect(q *Query) {
 	var pairs [][2]string
 	var err error
 
-	if RandomFromWeighted(highspeedWeights) {
+	if RandomFromWeighted(q.Rand, highspeedWeights) {
 		log.Println("Querying high-speed orders for collection")
 		pairs, err = q.QueryOrders([]int{1}, false)
 	} else {
@@ -73,7 +244,7 @@ func QueryAndCollect(q *Query) {
 		return
 	}
 
-	pair := RandomFromList(pairs).([2]string)
+	pair := RandomFromList(q.Rand, pairs).([2]string)
 	orderID := pair[0]
 
 	log.Printf("Selected order %s for collection", orderID)
@@ -94,11 +265,13 @@ func QueryOnlyHighSpeed(q *Query) {
 	var tripIDs []string
 	var tripDate string
 	var err error
+	date := Dates.Next(q.Rand)
 
-	start = "Shang Hai"
-	end = "Su Zhou"
-	log.Printf("Querying high-speed ticket from %s to %s for date %s", start, end, BaseDate.Format("2006-01-02"))
-	tripIDs, tripDate, err = q.QueryHighSpeedTicket([2]string{start, end}, BaseDate)
+	pair := Catalog.Sample(q.Rand, true)
+	start = pair[0]
+	end = pair[1]
+	log.Printf("Querying high-speed ticket from %s to %s for date %s", start, end, date.Format("2006-01-02"))
+	tripIDs, tripDate, err = q.QueryHighSpeedTicket([2]string{start, end}, date)
 
 	if err != nil {
 		log.Printf("Error querying tickets: %v", err)
@@ -108,13 +281,96 @@ func QueryOnlyHighSpeed(q *Query) {
 	log.Printf("Found %d trips. Trip date: %s", len(tripIDs), tripDate)
 
 	if len(tripIDs) == 0 {
-		log.Printf("No trips available from %s to %s on %s", start, end, BaseDate.Format("2006-01-02"))
+		log.Printf("No trips available from %s to %s on %s", start, end, date.Format("2006-01-02"))
 		return
 	}
 
 	log.Printf("High-speed tickets queried successfully from %s to %s for %s", start, end, tripDate)
 }
 
+// QueryTravelPlan runs a cheapest, quickest or minStation search, the
+// deepest call graphs in Train-Ticket
+func QueryTravelPlan(q *Query) {
+	log.Println("Starting QueryTravelPlan operation")
+	date := Dates.Next(q.Rand)
+	pair := Catalog.Sample(q.Rand, RandomFromWeighted(q.Rand, highspeedWeights))
+	planType := RandomFromList(q.Rand, PlanTypes).(string)
+
+	log.Printf("Querying %s travel plan from %s to %s for date %s", planType, pair[0], pair[1], date.Format("2006-01-02"))
+	plans, err := q.QueryTravelPlan(planType, pair, date)
+	if err != nil {
+		log.Printf("Error querying travel plan: %v", err)
+		return
+	}
+
+	if len(plans) == 0 {
+		log.Printf("No %s travel plan from %s to %s on %s", planType, pair[0], pair[1], date.Format("2006-01-02"))
+		return
+	}
+
+	log.Printf("Found %d %s travel plans from %s to %s, first trip %s", len(plans), planType, pair[0], pair[1], plans[0].TripID)
+}
+
+func QueryOnlyHighSpeedParallel(q *Query) {
+	log.Println("Starting QueryOnlyHighSpeedParallel operation")
+	date := Dates.Next(q.Rand)
+	pair := Catalog.Sample(q.Rand, true)
+
+	log.Printf("Querying high-speed ticket in parallel from %s to %s for date %s", pair[0], pair[1], date.Format("2006-01-02"))
+	tripIDs, err := q.QueryHighSpeedTicketParallel(pair, date)
+	if err != nil {
+		log.Printf("Error querying tickets in parallel: %v", err)
+		return
+	}
+
+	if len(tripIDs) == 0 {
+		log.Printf("No trips available from %s to %s on %s", pair[0], pair[1], date.Format("2006-01-02"))
+		return
+	}
+
+	log.Printf("High-speed tickets queried in parallel successfully from %s to %s, found %d trips", pair[0], pair[1], len(tripIDs))
+}
+
+// CompareHighSpeedSearch issues the sequential and the parallel trip search
+// for the same pair and date, in random order, and records the latency of
+// each so the two endpoints can be compared head-to-head in one run
+func CompareHighSpeedSearch(q *Query) {
+	log.Println("Starting CompareHighSpeedSearch operation")
+	date := Dates.Next(q.Rand)
+	pair := Catalog.Sample(q.Rand, true)
+
+	searches := []struct {
+		name   string
+		search func() ([]string, error)
+	}{
+		{"search:trips/left", func() ([]string, error) {
+			tripIDs, _, err := q.QueryHighSpeedTicket(pair, date)
+			return tripIDs, err
+		}},
+		{"search:trips/left_parallel", func() ([]string, error) {
+			return q.QueryHighSpeedTicketParallel(pair, date)
+		}},
+	}
+
+	// Alternate the order so neither endpoint benefits from warm caches
+	if RandomBoolean(q.Rand) {
+		searches[0], searches[1] = searches[1], searches[0]
+	}
+
+	for _, s := range searches {
+		start := time.Now()
+		tripIDs, err := s.search()
+		latency := time.Since(start)
+		if err != nil {
+			log.Printf("Error in %s from %s to %s: %v", s.name, pair[0], pair[1], err)
+			continue
+		}
+
+		stats.RecordLatency(s.name, latency)
+		log.Printf("%s from %s to %s found %d trips in %v", s.name, pair[0], pair[1], len(tripIDs), latency)
+	}
+}
+
 func QueryAndPreserve(q *Query) {
 	log.Println("Starting QueryAndPreserve operation")
 	start := ""
@@ -122,18 +378,18 @@ func QueryAndPreserve(q *Query) {
 	var tripIDs []string
 	var tripDate string
 	var err error
+	date := Dates.Next(q.Rand)
 
-	highSpeed := RandomFromWeighted(highspeedWeights)
+	highSpeed := RandomFromWeighted(q.Rand, highspeedWeights)
+	pair := Catalog.Sample(q.Rand, highSpeed)
+	start = pair[0]
+	end = pair[1]
 	if highSpeed {
-		start = "Shang Hai"
-		end = "Su Zhou"
-		log.Printf("Querying high-speed ticket from %s to %s for date %s", start, end, BaseDate.Format("2006-01-02"))
-		tripIDs, tripDate, err = q.QueryHighSpeedTicket([2]string{start, end}, BaseDate)
+		log.Printf("Querying high-speed ticket from %s to %s for date %s", start, end, date.Format("2006-01-02"))
+		tripIDs, tripDate, err = q.QueryHighSpeedTicket([2]string{start, end}, date)
 	} else {
-		start = "Shang Hai"
-		end = "Nan Jing"
-		log.Printf("Querying normal ticket from %s to %s for date %s", start, end, BaseDate.Format("2006-01-02"))
-		tripIDs, tripDate, err = q.QueryNormalTicket([2]string{start, end}, BaseDate)
+		log.Printf("Querying normal ticket from %s to %s for date %s", start, end, date.Format("2006-01-02"))
+		tripIDs, tripDate, err = q.QueryNormalTicket([2]string{start, end}, date)
 	}
 
 	if err != nil {
@@ -144,12 +400,14 @@ func QueryAndPreserve(q *Query) {
 	log.Printf("Found %d trips. Trip date: %s", len(tripIDs), tripDate)
 
 	if len(tripIDs) == 0 {
-		log.Printf("No trips available from %s to %s on %s", start, end, BaseDate.Format("2006-01-02"))
+		log.Printf("No trips available from %s to %s on %s", start, end, date.Format("2006-01-02"))
 		return
 	}
 
+	StepPause(q)
+
 	log.Println("Attempting to preserve ticket")
-	err = q.Preserve(start, end, tripIDs, highSpeed, tripDate)
+	_, err = q.Preserve(start, end, tripIDs, highSpeed, tripDate)
 	if err != nil {
 		log.Printf("Error preserving ticket: %v", err)
 		return
@@ -163,7 +421,7 @@ func QueryAndConsign(q *Query) {
 	var list []map[string]interface{}
 	var err error
 
-	if RandomFromWeighted(highspeedWeights) {
+	if RandomFromWeighted(q.Rand, highspeedWeights) {
 		log.Println("Querying high-speed orders for consignment")
 		list, err = q.QueryOrdersAllInfo(false)
 	} else {
@@ -213,7 +471,7 @@ func QueryAndPay(q *Query) {
 	var pairs [][2]string
 	var err error
 
-	if RandomFromWeighted(highspeedWeights) {
+	if RandomFromWeighted(q.Rand, highspeedWeights) {
 		pairs, err = q.QueryOrders([]int{0, 1}, false)
 	} else {
 		pairs, err = q.QueryOrders([]int{0, 1}, true)
@@ -229,7 +487,7 @@ func QueryAndPay(q *Query) {
 		return
 	}
 
-	pair := RandomFromList(pairs).([2]string)
+	pair := RandomFromList(q.Rand, pairs).([2]string)
 	orderID, tripID := pair[0], pair[1]
 
 	err = q.PayOrder(orderID, tripID)
@@ -241,54 +499,282 @@ func QueryAndPay(q *Query) {
 	log.Printf("Order %s queried and paid", orderID)
 }
 
-func QueryAndRebook(q *Query) {
-	log.Println("Starting QueryAndRebook operation")
-	var pairs [][2]string
-	var err error
+// AddContactAndBook registers a new passenger with a random name, document
+// and phone, sometimes corrects the phone number, and books a ticket for the
+// new passenger
+func AddContactAndBook(q *Query) {
+	log.Println("Starting AddContactAndBook operation")
 
-	if RandomFromWeighted(highspeedWeights) {
-		log.Println("Querying high-speed orders for rebooking")
-		pairs, err = q.QueryOrders([]int{0, 1}, false)
-	} else {
-		log.Println("Querying normal orders for rebooking")
-		pairs, err = q.QueryOrders([]int{0, 1}, true)
+	start := time.Now()
+	contact, err := q.AddContact(NewContact(q.Rand, q.UID))
+	stats.RecordLatency("AddContactAndBook/add_contact", time.Since(start))
+	if err != nil {
+		log.Printf("Error adding contact: %v", err)
+		stats.RecordOutcome("AddContactAndBook", "failed at add_contact")
+		return
 	}
+	log.Printf("Added contact %s (%s)", contact.Name, contact.ID)
+
+	if q.Rand.Float64() < 0.3 {
+		StepPause(q)
+		contact.PhoneNumber = RandomPhone(q.Rand)
+		start = time.Now()
+		err = q.ModifyContact(*contact)
+		stats.RecordLatency("AddContactAndBook/modify_contact", time.Since(start))
+		if err != nil {
+			log.Printf("Error modifying contact %s: %v", contact.ID, err)
+			stats.RecordOutcome("AddContactAndBook", "failed at modify_contact")
+			return
+		}
+	}
+
+	StepPause(q)
+
+	st := &JourneyState{}
+	if err := RunStep(q, st, "AddContactAndBook", "search", StepParams{}); err != nil {
+		log.Printf("Error searching trips for contact %s: %v", contact.ID, err)
+		stats.RecordOutcome("AddContactAndBook", "failed at search")
+		return
+	}
+
+	StepPause(q)
 
+	start = time.Now()
+	_, err = q.PreserveFor(contact.ID, st.Pair[0], st.Pair[1], st.TripIDs, st.HighSpeed, st.TripDate)
+	stats.RecordLatency("AddContactAndBook/preserve", time.Since(start))
+	if err != nil {
+		log.Printf("Error booking for contact %s: %v", contact.ID, err)
+		stats.RecordOutcome("AddContactAndBook", "failed at preserve")
+		return
+	}
+
+	stats.RecordOutcome("AddContactAndBook", "completed")
+	log.Printf("Booked %s to %s on %s for new contact %s", st.Pair[0], st.Pair[1], st.TripDate, contact.Name)
+}
+
+// ConsignLifecycle creates a consignment for an order (or picks up the one it
+// has), reads it back by order and by account, modifies it and checks the
+// modification was stored
+func ConsignLifecycle(q *Query) {
+	log.Println("Starting ConsignLifecycle operation")
+
+	orders, err := q.QueryOrdersAllInfo(!RandomFromWeighted(q.Rand, highspeedWeights))
+	if err != nil {
+		log.Printf("Error querying orders for consignment: %v", err)
+		stats.RecordOutcome("ConsignLifecycle", "order query failed")
+		return
+	}
+
+	var candidates []map[string]interface{}
+	for _, order := range orders {
+		status, ok := order["status"].(float64)
+		if ok && int(status) <= OrderCollected {
+			candidates = append(candidates, order)
+		}
+	}
+
+	if len(candidates) == 0 {
+		log.Println("No orders found for consignment")
+		stats.RecordOutcome("ConsignLifecycle", "no order")
+		return
+	}
+
+	order := RandomFromList(q.Rand, candidates).(map[string]interface{})
+	orderID, _ := order["orderId"].(string)
+	accountID, _ := order["accountId"].(string)
+	from, _ := order["from"].(string)
+	to, _ := order["to"].(string)
+
+	step := func(name string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		stats.RecordLatency("ConsignLifecycle/"+name, time.Since(start))
+		if err != nil {
+			log.Printf("ConsignLifecycle failed at %s for order %s: %v", name, orderID, err)
+			stats.RecordOutcome("ConsignLifecycle", "failed at "+name)
+			return false
+		}
+		return true
+	}
+
+	var consign *Consign
+	lookup := func() (err error) {
+		consign, err = q.QueryConsignByOrder(orderID)
+		return err
+	}
+
+	if !step("query_order", lookup) {
+		return
+	}
+
+	if consign == nil {
+		created := NewConsign(q.Rand, accountID, orderID, from, to)
+		if !step("insert", func() error { return q.InsertConsign(created) }) {
+			return
+		}
+		StepPause(q)
+		if !step("query_order", lookup) {
+			return
+		}
+		if consign == nil {
+			log.Printf("Consignment of order %s not found after insert", orderID)
+			stats.RecordOutcome("ConsignLifecycle", "insert not visible")
+			return
+		}
+	}
+
+	StepPause(q)
+
+	if !step("query_account", func() error {
+		consigns, err := q.QueryConsignsByAccount(accountID)
+		if err != nil {
+			return err
+		}
+		for _, c := range consigns {
+			if c.ID == consign.ID {
+				return nil
+			}
+		}
+		return fmt.Errorf("consignment %s missing among %d of account %s", consign.ID, len(consigns), accountID)
+	}) {
+		return
+	}
+
+	StepPause(q)
+
+	modified := *consign
+	modified.Randomize(q.Rand)
+	if !step("update", func() error { return q.UpdateConsign(modified) }) {
+		return
+	}
+	if !step("query_order", lookup) {
+		return
+	}
+
+	if consign == nil || consign.Consignee != modified.Consignee || consign.Phone != modified.Phone {
+		log.Printf("Update of consignment for order %s was not stored", orderID)
+		stats.RecordOutcome("ConsignLifecycle", "update not visible")
+		return
+	}
+
+	stats.RecordOutcome("ConsignLifecycle", "completed")
+	log.Printf("Consignment %s of order %s created, queried and modified", consign.ID, orderID)
+}
+
+// QueryAndRebook moves a paid order to another trip of its route, or to the
+// same trip on another date, paying the price difference when the rebook
+// service asks for it. Every run records its outcome, so successful rebooks
+// and the reasons of failed ones are reported separately.
+func QueryAndRebook(q *Query) {
+	log.Println("Starting QueryAndRebook operation")
+
+	highSpeed := RandomFromWeighted(q.Rand, highspeedWeights)
+	orders, err := q.QueryOrdersAllInfo(!highSpeed)
 	if err != nil {
 		log.Printf("Error querying orders for rebooking: %v", err)
+		stats.RecordOutcome("QueryAndRebook", "order query failed")
 		return
 	}
 
-	if len(pairs) == 0 {
-		log.Println("No orders found for rebooking")
+	// Only paid orders can be rebooked, and only once
+	var paid []map[string]interface{}
+	for _, order := range orders {
+		if status, ok := order["status"].(float64); ok && int(status) == OrderPaid {
+			paid = append(paid, order)
+		}
+	}
+
+	if len(paid) == 0 {
+		log.Println("No paid orders found for rebooking")
+		stats.RecordOutcome("QueryAndRebook", "no paid order")
 		return
 	}
 
-	pair := RandomFromList(pairs).([2]string)
-	orderID, tripID := pair[0], pair[1]
+	order := RandomFromList(q.Rand, paid).(map[string]interface{})
+	orderID, _ := order["orderId"].(string)
+	oldTripID, _ := order["trainNumber"].(string)
+	from, _ := order["from"].(string)
+	to, _ := order["to"].(string)
+	pair := [2]string{Catalog.StationName(from), Catalog.StationName(to)}
 
-	log.Printf("Selected order %s (Trip: %s) for rebooking", orderID, tripID)
+	log.Printf("Selected order %s (Trip: %s) for rebooking", orderID, oldTripID)
 
-	// First, cancel the order
-	err = q.CancelOrder(orderID, q.UID)
+	date := Dates.Next(q.Rand)
+	var tripIDs []string
+	var tripDate string
+	if highSpeed {
+		tripIDs, tripDate, err = q.QueryHighSpeedTicket(pair, date)
+	} else {
+		tripIDs, tripDate, err = q.QueryNormalTicket(pair, date)
+	}
 	if err != nil {
-		log.Printf("Error cancelling order %s: %v", orderID, err)
+		log.Printf("Error querying trips from %s to %s for rebooking: %v", pair[0], pair[1], err)
+		stats.RecordOutcome("QueryAndRebook", "trip query failed")
 		return
 	}
-	log.Printf("Order %s successfully canceled", orderID)
+	if tripDate == "" {
+		tripDate = date.Format("2006-01-02")
+	}
+
+	// The same trip is only an alternative on another day
+	sameDay := cachedTravelDay(order, tripDate)
+	var candidates []string
+	for _, tripID := range tripIDs {
+		if tripID != oldTripID || !sameDay {
+			candidates = append(candidates, tripID)
+		}
+	}
+
+	if len(candidates) == 0 {
+		log.Printf("No alternative trip from %s to %s on %s", pair[0], pair[1], tripDate)
+		stats.RecordOutcome("QueryAndRebook", "no alternative trip")
+		return
+	}
+
+	StepPause(q)
 
-	// Now attempt to rebook
-	newTripID := tripID // For simplicity, we're rebooking to the same trip
-	newDate := time.Now().Format("2006-01-02")
-	newSeatType := RandomFromList([]string{"2", "3"}).(string)
+	newTripID := RandomFromList(q.Rand, candidates).(string)
+	newSeatType := RandomFromList(q.Rand, []string{"2", "3"}).(string)
 
-	err = q.RebookTicket(orderID, tripID, newTripID, newDate, newSeatType)
+	result, err := q.RebookTicket(orderID, oldTripID, newTripID, tripDate, newSeatType)
 	if err != nil {
 		log.Printf("Error rebooking ticket for order %s: %v", orderID, err)
+		stats.RecordOutcome("QueryAndRebook", "request failed")
 		return
 	}
 
-	log.Printf("Order %s successfully rebooked to trip %s on %s with seat type %s", orderID, newTripID, newDate, newSeatType)
+	switch result.Status {
+	case RebookDone:
+		stats.RecordOutcome("QueryAndRebook", "rebooked")
+	case RebookDifferenceDue:
+		log.Printf("Paying the price difference to rebook order %s", orderID)
+		err = q.PayRebookDifference(orderID, oldTripID, newTripID, tripDate, newSeatType)
+		if err != nil {
+			log.Printf("Error paying the rebook difference for order %s: %v", orderID, err)
+			stats.RecordOutcome("QueryAndRebook", "difference payment failed")
+			return
+		}
+		stats.RecordOutcome("QueryAndRebook", "rebooked with difference")
+	default:
+		log.Printf("Rebook of order %s rejected: %s", orderID, result.Msg)
+		stats.RecordOutcome("QueryAndRebook", "rejected: "+result.Msg)
+		return
+	}
+
+	log.Printf("Order %s successfully rebooked to trip %s on %s with seat type %s", orderID, newTripID, tripDate, newSeatType)
+}
+
+// cachedTravelDay reports whether a cached order travels on day. Depending on
+// the release the travel date was decoded as a string or epoch milliseconds.
+func cachedTravelDay(order map[string]interface{}, day string) bool {
+	var date looseString
+	switch v := order["travelDate"].(type) {
+	case string:
+		date = looseString(v)
+	case float64:
+		date = looseString(strconv.FormatInt(int64(v), 10))
+	}
+	return Order{TravelDate: date}.TravelDay(day)
 }
 
 func QueryAndExecute(q *Query) {
@@ -296,7 +782,7 @@ func QueryAndExecute(q *Query) {
 	var pairs [][2]string
 	var err error
 
-	if RandomFromWeighted(highspeedWeights) {
+	if RandomFromWeighted(q.Rand, highspeedWeights) {
 		log.Println("Querying high-speed orders for execution")
 		pairs, err = q.QueryOrders([]int{2}, false)
 	} else {
@@ -314,7 +800,7 @@ func QueryAndExecute(q *Query) {
 		return
 	}
 
-	pair := RandomFromList(pairs).([2]string)
+	pair := RandomFromList(q.Rand, pairs).([2]string)
 	orderID := pair[0]
 
 	log.Printf("Selected order %s for execution", orderID)
@@ -327,3 +813,142 @@ func QueryAndExecute(q *Query) {
 
 	log.Printf("Order %s successfully queried and executed (entered station)", orderID)
 }
+
+func scenarioNames(scenarios []Scenario) string {
+	names := make([]string, len(scenarios))
+	for i, scenario := range scenarios {
+		names[i] = scenario.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func AdminQueryPrices(q *Query) {
+	log.Println("Starting AdminQueryPrices operation")
+	admin, err := q.AdminQuery()
+	if err != nil {
+		log.Printf("Error logging in as admin: %v", err)
+		return
+	}
+
+	prices, err := admin.QueryAdminBasicPrice()
+	if err != nil {
+		log.Printf("Error querying admin prices: %v", err)
+		return
+	}
+
+	log.Printf("Admin queried %d price configs", len(prices))
+}
+
+func AdminQueryConfigs(q *Query) {
+	log.Println("Starting AdminQueryConfigs operation")
+	admin, err := q.AdminQuery()
+	if err != nil {
+		log.Printf("Error logging in as admin: %v", err)
+		return
+	}
+
+	configs, err := admin.QueryAdminBasicConfig()
+	if err != nil {
+		log.Printf("Error querying admin configs: %v", err)
+		return
+	}
+
+	log.Printf("Admin queried %d configs", len(configs))
+}
+
+func AdminQueryTravel(q *Query) {
+	log.Println("Starting AdminQueryTravel operation")
+	admin, err := q.AdminQuery()
+	if err != nil {
+		log.Printf("Error logging in as admin: %v", err)
+		return
+	}
+
+	trips, err := admin.QueryAdminTravel()
+	if err != nil {
+		log.Printf("Error querying admin travel: %v", err)
+		return
+	}
+
+	log.Printf("Admin queried %d trips", len(trips))
+}
+
+// AdminQueryRoutes lists all routes and then opens one of them
+func AdminQueryRoutes(q *Query) {
+	log.Println("Starting AdminQueryRoutes operation")
+	admin, err := q.AdminQuery()
+	if err != nil {
+		log.Printf("Error logging in as admin: %v", err)
+		return
+	}
+
+	routes, err := admin.QueryRoutes()
+	if err != nil {
+		log.Printf("Error querying routes: %v", err)
+		return
+	}
+
+	if len(routes) == 0 {
+		log.Println("No routes found")
+		return
+	}
+
+	route := routes[q.Rand.Intn(len(routes))]
+	if err := admin.QueryRoute(route.ID); err != nil {
+		log.Printf("Error querying route %s: %v", route.ID, err)
+		return
+	}
+
+	log.Printf("Admin queried %d routes and opened route %s", len(routes), route.ID)
+}
+
+// AdminBrowse walks through the admin pages the way an operator would
+func AdminBrowse(q *Query) {
+	log.Println("Starting AdminBrowse operation")
+	AdminQueryPrices(q)
+	StepPause(q)
+	AdminQueryConfigs(q)
+	StepPause(q)
+	AdminQueryTravel(q)
+	StepPause(q)
+	AdminQueryRoutes(q)
+}
+
+// FullJourney follows a single order through its whole lifecycle: search,
+// preserve, locate the new order, pay, optionally consign, collect and enter
+// the station. Every step is timed under "FullJourney/<step>"; completed
+// journeys record their end-to-end latency (think times excluded) and every
+// run records the step it failed at, if any. A journey whose order can't be
+// identified unambiguously ends at locate, so it never acts on an order
+// another worker booked.
+func FullJourney(q *Query) {
+	log.Println("Starting FullJourney operation")
+
+	steps := []string{"search", "preserve", "locate", "pay"}
+	if RandomBoolean(q.Rand) {
+		steps = append(steps, "consign")
+	}
+	steps = append(steps, "collect", "enter")
+
+	st := &JourneyState{}
+	defer st.ReleaseOrders()
+	p := StepParams{Order: "current"}
+	thought := q.Thinking()
+	start := time.Now()
+
+	for i, action := range steps {
+		if i > 0 {
+			StepPause(q)
+		}
+		if err := RunStep(q, st, "FullJourney", action, p); err != nil {
+			log.Printf("FullJourney failed at %s: %v", action, err)
+			stats.RecordOutcome("FullJourney", "failed at "+action)
+			return
+		}
+	}
+
+	latency := time.Since(start) - (q.Thinking() - thought)
+	stats.RecordLatency("FullJourney/end-to-end", latency)
+	stats.RecordOutcome("FullJourney", "completed")
+	log.Printf("FullJourney completed order %s in %v", st.OrderID, latency)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BurstChange is one entry of a burst schedule: At after the start of the
+// load test, set the burst parameters of Service, or with Reset restore the
+// parameters it had when the load test started
+type BurstChange struct {
+	At       time.Duration `yaml:"at"`
+	Service  string        `yaml:"service"`
+	Period   int           `yaml:"period"`
+	Rate     int           `yaml:"rate"`
+	Duration int           `yaml:"duration"`
+	Reset    bool          `yaml:"reset"`
+}
+
+func (c BurstChange) Params() BurstParams {
+	return BurstParams{Period: c.Period, Rate: c.Rate, Duration: c.Duration}
+}
+
+func (c BurstChange) String() string {
+	if c.Reset {
+		return fmt.Sprintf("t=%v reset %s", c.At, c.Service)
+	}
+	return fmt.Sprintf("t=%v set %s to %s", c.At, c.Service, c.Params())
+}
+
+// ParseBurstSchedule parses "<at>:<service>=<period>/<rate>/<duration>" or
+// "<at>:<service>=reset" entries separated by commas, such as
+// "120s:ts-seat-service=60/5/10,300s:ts-seat-service=reset", or reads a
+// YAML or JSON file with a "schedule" list of BurstChange entries
+func ParseBurstSchedule(spec string) ([]BurstChange, error) {
+	var schedule []BurstChange
+
+	if !strings.Contains(spec, "=") {
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read burst schedule: %v", err)
+		}
+		var file struct {
+			Schedule []BurstChange `yaml:"schedule"`
+		}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse burst schedule: %v", err)
+		}
+		schedule = file.Schedule
+	} else {
+		for _, token := range strings.Split(spec, ",") {
+			token = strings.TrimSpace(token)
+			if token == "" {
+				continue
+			}
+			change, err := parseBurstChange(token)
+			if err != nil {
+				return nil, err
+			}
+			schedule = append(schedule, change)
+		}
+	}
+
+	for i, change := range schedule {
+		if _, err := Services.Lookup(change.Service); err != nil {
+			return nil, fmt.Errorf("schedule entry %d: %v", i, err)
+		}
+		if change.At < 0 {
+			return nil, fmt.Errorf("schedule entry %d: negative time", i)
+		}
+	}
+
+	sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].At < schedule[j].At })
+	return schedule, nil
+}
+
+func parseBurstChange(token string) (BurstChange, error) {
+	var change BurstChange
+
+	atStr, rest, ok := strings.Cut(token, ":")
+	service, paramStr, ok2 := strings.Cut(rest, "=")
+	if !ok || !ok2 {
+		return change, fmt.Errorf("invalid schedule entry %q, expected <at>:<service>=<period>/<rate>/<duration> or reset", token)
+	}
+
+	at, err := time.ParseDuration(strings.TrimSpace(atStr))
+	if err != nil {
+		return change, fmt.Errorf("invalid time in %q: %v", token, err)
+	}
+	change.At, change.Service = at, strings.TrimSpace(service)
+
+	if strings.TrimSpace(paramStr) == "reset" {
+		change.Reset = true
+		return change, nil
+	}
+
+	values := strings.Split(paramStr, "/")
+	if len(values) != 3 {
+		return change, fmt.Errorf("invalid parameters in %q, expected <period>/<rate>/<duration>", token)
+	}
+	params := make([]int, 3)
+	for i, value := range values {
+		if params[i], err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+			return change, fmt.Errorf("invalid parameters in %q: %v", token, err)
+		}
+	}
+	change.Period, change.Rate, change.Duration = params[0], params[1], params[2]
+
+	return change, nil
+}
+
+// RunBurstSchedule applies the schedule relative to start until it is done
+// or stopChan closes. The parameters every scheduled service had before are
+// read first, so Reset entries can restore them. If the load test stops
+// before the schedule is done, the pending Reset entries of services the
+// schedule changed are applied right away, so no burst outlives the run
+// unless the schedule never resets it. Every change is logged and recorded
+// in the time series.
+func RunBurstSchedule(client *ControlClient, schedule []BurstChange, start time.Time, stopChan <-chan struct{}) {
+	original := make(map[string]BurstParams)
+	for _, change := range schedule {
+		if _, ok := original[change.Service]; ok {
+			continue
+		}
+		params, err := client.GetBurstParams(change.Service)
+		if err != nil {
+			log.Printf("Burst schedule: %v, resetting %s will disable bursts", err, change.Service)
+		}
+		original[change.Service] = params
+		log.Printf("Burst schedule: %s starts with %s", change.Service, params)
+	}
+
+	changed := make(map[string]bool)
+	apply := func(change BurstChange) {
+		params := change.Params()
+		if change.Reset {
+			params = original[change.Service]
+		}
+
+		outcome := applyBurstParams(client, change.Service, params, "schedule "+change.String())
+		if !outcome.Applied {
+			log.Printf("Burst schedule: %s failed: %s", change, outcome.Error)
+			series.Event("burst %s %s failed: %s", change.Service, params, outcome.Error)
+			return
+		}
+
+		changed[change.Service] = !change.Reset
+		if outcome.Acknowledged {
+			log.Printf("Burst schedule: applied %s", change)
+		} else {
+			log.Printf("Burst schedule: applied %s, but not acknowledged: %s", change, outcome.Error)
+		}
+		series.Event("burst %s period=%d rate=%d duration=%d acknowledged=%t", change.Service, params.Period, params.Rate, params.Duration, outcome.Acknowledged)
+	}
+
+	for i, change := range schedule {
+		if sleepOrStop(time.Until(start.Add(change.At)), stopChan) {
+			apply(change)
+			continue
+		}
+
+		log.Printf("Burst schedule: load test stopped, applying the pending resets")
+		for _, pending := range schedule[i:] {
+			if pending.Reset && changed[pending.Service] {
+				apply(pending)
+			}
+		}
+		break
+	}
+
+	for _, service := range Services.Names() {
+		if changed[service] {
+			log.Printf("Burst schedule: %s still has the scheduled parameters after the run", service)
+		}
+	}
+}
+
+// BurstOutcome is what became of a burst parameter change. A change can be
+// applied, the service accepted it, without being acknowledged, when reading
+// the parameters back failed or returned something else.
+type BurstOutcome struct {
+	Applied      bool         `json:"applied"`
+	Acknowledged bool         `json:"acknowledged"`
+	Observed     *BurstParams `json:"observed,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// applyBurstParams sets the burst parameters of service and reads them back
+// as acknowledgement, annotating the request and its outcome
+func applyBurstParams(client *ControlClient, service string, params BurstParams, reason string) BurstOutcome {
+	annotations.Record(Annotation{Event: AnnotationBurstSet, Service: service, Params: &params, Reason: reason})
+
+	var outcome BurstOutcome
+	event := AnnotationBurstFailed
+	if err := client.SetBurstParams(service, params); err != nil {
+		outcome.Error = err.Error()
+	} else if observed, err := client.GetBurstParams(service); err != nil {
+		outcome.Applied = true
+		outcome.Error = fmt.Sprintf("read-back failed: %v", err)
+		event = AnnotationBurstUnack
+	} else {
+		outcome.Applied, outcome.Observed = true, &observed
+		if observed == params {
+			outcome.Acknowledged = true
+			event = AnnotationBurstAck
+		} else {
+			outcome.Error = fmt.Sprintf("%s reports %s after setting %s", service, observed, params)
+			event = AnnotationBurstUnack
+		}
+	}
+
+	annotations.Record(Annotation{
+		Event:        event,
+		Service:      service,
+		Params:       &params,
+		Applied:      &outcome.Applied,
+		Acknowledged: &outcome.Acknowledged,
+		Observed:     outcome.Observed,
+		Reason:       reason,
+		Error:        outcome.Error,
+	})
+	return outcome
+}
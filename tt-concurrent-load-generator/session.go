@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SessionEnd is the terminal state of every session model
+const SessionEnd = "end"
+
+// SessionModel describes user sessions as a Markov chain over step actions.
+// Every state runs one step, pauses for its think time and moves on to the
+// next state with the probabilities of its transition row.
+type SessionModel struct {
+	Start       string                   `yaml:"start"`
+	MaxSteps    int                      `yaml:"maxSteps"`
+	MaxDuration time.Duration            `yaml:"maxDuration"`
+	States      map[string]*SessionState `yaml:"states"`
+}
+
+type SessionState struct {
+	// Action is the step the state runs, default the state name
+	Action     string `yaml:"action"`
+	StepParams `yaml:",inline"`
+	// Think is the pause after the state, default the -think-step setting
+	Think *ThinkTime         `yaml:"think"`
+	Next  map[string]float64 `yaml:"next"`
+
+	// transitions is Next in a stable order, so seeded runs are reproducible
+	transitions []transition
+}
+
+type transition struct {
+	to          string
+	probability float64
+}
+
+// SessionFunnel lists the actions reported as the completion funnel. A
+// session counts for a stage only if it also reached every earlier stage.
+var SessionFunnel = []string{"search", "preserve", "pay", "collect", "enter"}
+
+// DefaultSessionModel searches a few times, books, pays and later collects
+// and enters, with occasional cancellations along the way. Every booking is
+// located right away, and the later states act on that order.
+func DefaultSessionModel() *SessionModel {
+	think := &ThinkTime{Dist: "exponential", Mean: 2 * time.Second}
+	current := StepParams{Order: "current"}
+	return &SessionModel{
+		Start:       "search",
+		MaxSteps:    30,
+		MaxDuration: 10 * time.Minute,
+		States: map[string]*SessionState{
+			"search":   {Think: think, Next: map[string]float64{"search": 0.35, "plan": 0.15, "preserve": 0.35, SessionEnd: 0.15}},
+			"plan":     {Think: think, Next: map[string]float64{"search": 0.5, "preserve": 0.3, SessionEnd: 0.2}},
+			"preserve": {Think: &ThinkTime{}, Next: map[string]float64{"locate": 1}},
+			"locate":   {Think: think, Next: map[string]float64{"pay": 0.75, "search": 0.1, SessionEnd: 0.15}},
+			"pay":      {StepParams: current, Think: think, Next: map[string]float64{"collect": 0.6, "cancel": 0.1, SessionEnd: 0.3}},
+			"cancel":   {StepParams: current, Think: think, Next: map[string]float64{"search": 0.4, SessionEnd: 0.6}},
+			"collect":  {StepParams: current, Think: think, Next: map[string]float64{"enter": 0.8, "consign": 0.1, SessionEnd: 0.1}},
+			"consign":  {StepParams: current, Think: think, Next: map[string]float64{"enter": 0.8, SessionEnd: 0.2}},
+			"enter":    {StepParams: current, Think: think, Next: map[string]float64{SessionEnd: 1}},
+		},
+	}
+}
+
+// LoadSessionModel reads a session model from a YAML or JSON file, or
+// returns the default model for "builtin"
+func LoadSessionModel(path string) (*SessionModel, error) {
+	model := DefaultSessionModel()
+
+	if path != "builtin" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session model: %v", err)
+		}
+
+		model = &SessionModel{}
+		if err := yaml.Unmarshal(data, model); err != nil {
+			return nil, fmt.Errorf("failed to parse session model: %v", err)
+		}
+	}
+
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
+
+func (m *SessionModel) validate() error {
+	if _, ok := m.States[m.Start]; !ok {
+		return fmt.Errorf("start state %q is not defined", m.Start)
+	}
+	if m.MaxSteps <= 0 {
+		return fmt.Errorf("maxSteps must be positive")
+	}
+
+	for name, state := range m.States {
+		if name == SessionEnd {
+			return fmt.Errorf("%q is reserved for the terminal state", SessionEnd)
+		}
+		if state.Action == "" {
+			state.Action = name
+		}
+		if _, ok := StepActions[state.Action]; !ok {
+			return fmt.Errorf("state %s: unknown action %q (known: %s)", name, state.Action, stepNames())
+		}
+		if state.Think != nil {
+			if err := state.Think.Validate(); err != nil {
+				return fmt.Errorf("state %s: %v", name, err)
+			}
+		}
+
+		total := 0.0
+		state.transitions = state.transitions[:0]
+		for to, p := range state.Next {
+			if _, ok := m.States[to]; !ok && to != SessionEnd {
+				return fmt.Errorf("state %s: transition to undefined state %q", name, to)
+			}
+			if p < 0 {
+				return fmt.Errorf("state %s: negative transition probability", name)
+			}
+			total += p
+			state.transitions = append(state.transitions, transition{to, p})
+		}
+		if total < 0.999 || total > 1.001 {
+			return fmt.Errorf("state %s: transition probabilities add up to %.3f, not 1", name, total)
+		}
+		sort.Slice(state.transitions, func(i, j int) bool {
+			return state.transitions[i].to < state.transitions[j].to
+		})
+	}
+
+	return nil
+}
+
+func (s *SessionState) next(r *rand.Rand) string {
+	n := r.Float64()
+	for _, t := range s.transitions {
+		n -= t.probability
+		if n < 0 {
+			return t.to
+		}
+	}
+	return SessionEnd
+}
+
+// SessionResult summarises one session
+type SessionResult struct {
+	Steps    int
+	Duration time.Duration
+	// Reached holds the actions that succeeded at least once
+	Reached map[string]bool
+	// TimeToPurchase is the time from session start to the first payment
+	TimeToPurchase time.Duration
+	Purchased      bool
+	EndReason      string
+}
+
+// RunSession walks the model from its start state until it reaches the end
+// state, a session limit or the end of the load test (q.Stop closing)
+func RunSession(q *Query, model *SessionModel) SessionResult {
+	start := time.Now()
+	result := SessionResult{Reached: make(map[string]bool)}
+	st := &JourneyState{}
+	defer st.ReleaseOrders()
+
+	state := model.Start
+	for {
+		if state == SessionEnd {
+			result.EndReason = "completed"
+			break
+		}
+		if result.Steps >= model.MaxSteps {
+			result.EndReason = "max steps"
+			break
+		}
+		if model.MaxDuration > 0 && time.Since(start) >= model.MaxDuration {
+			result.EndReason = "max duration"
+			break
+		}
+
+		s := model.States[state]
+		err := RunStep(q, st, "session", s.Action, s.StepParams)
+		result.Steps++
+		if err != nil {
+			log.Printf("Session step %s failed: %v", state, err)
+		} else {
+			result.Reached[s.Action] = true
+			if s.Action == "pay" && !result.Purchased {
+				result.Purchased = true
+				result.TimeToPurchase = time.Since(start)
+			}
+		}
+
+		think := StepThink
+		if s.Think != nil {
+			think = *s.Think
+		}
+		if !q.Think(think.Sample(q.Rand)) {
+			result.EndReason = "interrupted"
+			break
+		}
+
+		state = s.next(q.Rand)
+	}
+
+	result.Duration = time.Since(start)
+	return result
+}
+
+// SessionStats aggregates the results of all sessions of a load test
+type SessionStats struct {
+	mu             sync.Mutex
+	sessions       int
+	reached        map[string]int
+	endReasons     map[string]int
+	steps          int
+	duration       time.Duration
+	timeToPurchase []time.Duration
+}
+
+func NewSessionStats() *SessionStats {
+	return &SessionStats{
+		reached:    make(map[string]int),
+		endReasons: make(map[string]int),
+	}
+}
+
+func (s *SessionStats) Record(result SessionResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions++
+	s.steps += result.Steps
+	s.duration += result.Duration
+	s.endReasons[result.EndReason]++
+	for _, action := range SessionFunnel {
+		if !result.Reached[action] {
+			break
+		}
+		s.reached[action]++
+	}
+	if result.Purchased {
+		s.timeToPurchase = append(s.timeToPurchase, result.TimeToPurchase)
+	}
+}
+
+// GetStats returns the completion funnel, session lengths and time to purchase
+func (s *SessionStats) GetStats() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := "\nSession Statistics:\n"
+	result += fmt.Sprintf("  Sessions: %d\n", s.sessions)
+	if s.sessions == 0 {
+		return result
+	}
+
+	result += fmt.Sprintf("  Mean steps per session: %.2f\n", float64(s.steps)/float64(s.sessions))
+	result += fmt.Sprintf("  Mean session duration: %.2f seconds\n", (s.duration / time.Duration(s.sessions)).Seconds())
+
+	result += "\n  Completion funnel (sessions that reached every stage up to this one):\n"
+	for _, action := range SessionFunnel {
+		count := s.reached[action]
+		result += fmt.Sprintf("  %-20s: %5d sessions (%6.2f%%)\n", action, count, 100*float64(count)/float64(s.sessions))
+	}
+
+	result += "\n  End reasons:\n"
+	reasons := make([]string, 0, len(s.endReasons))
+	for reason := range s.endReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		result += fmt.Sprintf("  %-20s: %5d\n", reason, s.endReasons[reason])
+	}
+
+	result += "\n  Time to purchase (s):\n"
+	if len(s.timeToPurchase) == 0 {
+		result += "  no purchases\n"
+		return result
+	}
+
+	sorted := append([]time.Duration(nil), s.timeToPurchase...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	result += fmt.Sprintf("  %-20s: %5d\n", "Purchases", len(sorted))
+	result += fmt.Sprintf("  %-20s: %8.2f\n", "Mean", (total / time.Duration(len(sorted))).Seconds())
+	result += fmt.Sprintf("  %-20s: %8.2f\n", "P50", percentile(sorted, 50).Seconds())
+	result += fmt.Sprintf("  %-20s: %8.2f\n", "P95", percentile(sorted, 95).Seconds())
+
+	return result
+}
@@ -37,9 +37,11 @@ func main() {
         {"QueryAndRebook", QueryAndRebook},
     }
 
+    Dates = UniformDate{Base: BaseDate, Days: 29}
+    Catalog = DefaultCatalog()
+
     for _, scenario := range scenarios {
-        UpdateBaseDate() // Update BaseDate to a new random date before each scenario
-        log.Printf("Using BaseDate %s for scenario: %s", BaseDate.Format("2006-01-02"), scenario.name)
+        log.Printf("Using travel dates %s for scenario: %s", Dates, scenario.name)
 
         q := NewQuery(url)
         log.Printf("Attempting to login for scenario: %s", scenario.name)
@@ -61,7 +63,7 @@ func main() {
     // _, err = q.QueryHighSpeedTicket([2]string{"Shang Hai", "Su Zhou"}, time.Now())
     // _, err = q.QueryNormalTicket([2]string{"Shang Hai", "Nan Jing"}, time.Now())
     // _, err = q.QueryAssurances()
-    // _, err = q.QueryFood([2]string{"Shang Hai", "Su Zhou"}, "D1345")
+    // _, err = q.QueryFood("2021-07-14", [2]string{"Shang Hai", "Su Zhou"}, "D1345")
     // _, err = q.QueryContacts()
     // _, err = q.QueryOrders([]int{0, 1}, false)
     // _, err = q.QueryOrdersAllInfo(false)
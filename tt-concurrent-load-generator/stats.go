@@ -2,6 +2,7 @@ package main
 
 import (
     "fmt"
+    "sort"
     "sync"
     "time"
 )
@@ -11,6 +12,10 @@ type ScenarioStats struct {
     mu sync.Mutex
     // Map of worker ID -> scenario name -> count
     stats map[int]map[string]int
+    // Map of scenario or request name -> observed latencies
+    latencies map[string][]time.Duration
+    // Map of scenario name -> outcome -> count
+    outcomes map[string]map[string]int
     startTime time.Time
 }
 
@@ -18,6 +23,8 @@ type ScenarioStats struct {
 func NewScenarioStats() *ScenarioStats {
     return &ScenarioStats{
         stats: make(map[int]map[string]int),
+        latencies: make(map[string][]time.Duration),
+        outcomes: make(map[string]map[string]int),
         startTime: time.Now(),
     }
 }
@@ -33,6 +40,122 @@ func (s *ScenarioStats) IncrementScenario(workerID int, scenarioName string) {
     s.stats[workerID][scenarioName]++
 }
 
+// RecordLatency safely records one observed latency for a scenario or request
+func (s *ScenarioStats) RecordLatency(name string, latency time.Duration) {
+    if s == nil {
+        return // Not collecting statistics, e.g. during warmup
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.latencies[name] = append(s.latencies[name], latency)
+}
+
+// RecordOutcome safely counts how one run of a scenario ended, such as
+// "completed" or "failed at pay"
+func (s *ScenarioStats) RecordOutcome(name, outcome string) {
+    if s == nil {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, exists := s.outcomes[name]; !exists {
+        s.outcomes[name] = make(map[string]int)
+    }
+    s.outcomes[name][outcome]++
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    if len(sorted) == 0 {
+        return 0
+    }
+    idx := int(p/100*float64(len(sorted))+0.5) - 1
+    if idx < 0 {
+        idx = 0
+    }
+    if idx >= len(sorted) {
+        idx = len(sorted) - 1
+    }
+    return sorted[idx]
+}
+
+// getLatencyStats formats the latency distribution of every recorded name.
+// Callers must hold s.mu.
+func (s *ScenarioStats) getLatencyStats() string {
+    if len(s.latencies) == 0 {
+        return ""
+    }
+
+    names := make([]string, 0, len(s.latencies))
+    for name := range s.latencies {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    result := "\nLatency Statistics (ms):\n"
+    result += fmt.Sprintf("  %-28s %7s %9s %9s %9s %9s %9s\n",
+        "Name", "Count", "Mean", "P50", "P95", "P99", "Max")
+
+    for _, name := range names {
+        sorted := append([]time.Duration(nil), s.latencies[name]...)
+        sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+        var total time.Duration
+        for _, latency := range sorted {
+            total += latency
+        }
+        mean := total / time.Duration(len(sorted))
+
+        result += fmt.Sprintf("  %-28s %7d %9.1f %9.1f %9.1f %9.1f %9.1f\n",
+            name, len(sorted), ms(mean), ms(percentile(sorted, 50)),
+            ms(percentile(sorted, 95)), ms(percentile(sorted, 99)), ms(sorted[len(sorted)-1]))
+    }
+
+    return result
+}
+
+// getOutcomeStats formats the outcome counts of every scenario that
+// records them. Callers must hold s.mu.
+func (s *ScenarioStats) getOutcomeStats() string {
+    if len(s.outcomes) == 0 {
+        return ""
+    }
+
+    names := make([]string, 0, len(s.outcomes))
+    for name := range s.outcomes {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    result := "\nOutcome Statistics:\n"
+    for _, name := range names {
+        total := 0
+        outcomes := make([]string, 0, len(s.outcomes[name]))
+        for outcome, count := range s.outcomes[name] {
+            outcomes = append(outcomes, outcome)
+            total += count
+        }
+        sort.Strings(outcomes)
+
+        result += fmt.Sprintf("  %s:\n", name)
+        for _, outcome := range outcomes {
+            count := s.outcomes[name][outcome]
+            result += fmt.Sprintf("    %-26s: %5d (%6.2f%%)\n",
+                outcome, count, 100*float64(count)/float64(total))
+        }
+    }
+
+    return result
+}
+
+func ms(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
 // GetStats returns formatted statistics for all workers and scenarios
 func (s *ScenarioStats) GetStats() string {
     s.mu.Lock()
@@ -93,6 +216,9 @@ func (s *ScenarioStats) GetStats() string {
     globalRate := float64(totalGlobal) / duration
     result += fmt.Sprintf("  %-20s: %5d total, %8.2f/sec\n",
         "Total", totalGlobal, globalRate)
+
+    result += s.getLatencyStats()
+    result += s.getOutcomeStats()
     
     return result
 }
\ No newline at end of file
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JourneyState carries what the earlier steps of a scenario found or picked,
+// so later steps can act on it (book a trip that was searched, collect the
+// order that was paid, ...)
+type JourneyState struct {
+	HighSpeed bool
+	Pair      [2]string
+	Date      time.Time
+	TripIDs   []string
+	TripDate  string
+	OrderID   string
+	TripID    string
+	// Booking and Order are set by the preserve and locate steps
+	Booking *Booking
+	Order   *Order
+	// claims lists the orders located for the bookings of the journey
+	claims []claim
+}
+
+type claim struct {
+	orderID string
+	booking *Booking
+}
+
+// ReleaseOrders releases the claims on every order the journey located.
+// Journeys release them when they end, completed or not, so orders they
+// left behind return to the order cache.
+func (st *JourneyState) ReleaseOrders() {
+	for _, c := range st.claims {
+		unclaimOrder(c.orderID, c.booking)
+	}
+	st.claims = nil
+}
+
+// StepParams selects where a step takes its inputs from
+type StepParams struct {
+	// Family is "highspeed", "normal" or "random" (default: the family of the
+	// last search, otherwise drawn from highspeedWeights)
+	Family string `yaml:"family"`
+	// Pair is "catalog" (default) or an explicit "From->To" pair
+	Pair string `yaml:"pair"`
+	// Order is "cache" (default, a random cached order in a suitable state) or
+	// "current" (the order an earlier step of this scenario worked on)
+	Order string `yaml:"order"`
+	// PlanType is "random" (default) or one of cheapest, quickest, minStation
+	PlanType string `yaml:"planType"`
+}
+
+// StepFunc executes one step of a scenario on behalf of q
+type StepFunc func(q *Query, st *JourneyState, p StepParams) error
+
+// StepActions maps step names to their implementation
+var StepActions = map[string]StepFunc{
+	"search":          stepSearch,
+	"search_parallel": stepSearchParallel,
+	"plan":            stepPlan,
+	"preserve":        stepPreserve,
+	"locate":          stepLocate,
+	"pay":             stepPay,
+	"cancel":          stepCancel,
+	"collect":         stepCollect,
+	"enter":           stepEnter,
+	"consign":         stepConsign,
+}
+
+func stepNames() string {
+	names := make([]string, 0, len(StepActions))
+	for name := range StepActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// RunStep runs the named step and records its latency under
+// "<prefix>/<action>"
+func RunStep(q *Query, st *JourneyState, prefix, action string, p StepParams) error {
+	fn, ok := StepActions[action]
+	if !ok {
+		return fmt.Errorf("unknown step %q", action)
+	}
+
+	start := time.Now()
+	err := fn(q, st, p)
+	stats.RecordLatency(prefix+"/"+action, time.Since(start))
+
+	return err
+}
+
+func (p StepParams) highSpeed(q *Query, st *JourneyState) bool {
+	switch p.Family {
+	case "highspeed":
+		return true
+	case "normal":
+		return false
+	case "random":
+		return RandomFromWeighted(q.Rand, highspeedWeights)
+	}
+
+	if st.Pair[0] != "" {
+		return st.HighSpeed
+	}
+	return RandomFromWeighted(q.Rand, highspeedWeights)
+}
+
+func (p StepParams) pair(q *Query, highSpeed bool) ([2]string, error) {
+	if p.Pair == "" || p.Pair == "catalog" {
+		return Catalog.Sample(q.Rand, highSpeed), nil
+	}
+
+	from, to, ok := strings.Cut(p.Pair, "->")
+	if !ok {
+		return [2]string{}, fmt.Errorf("invalid pair %q, expected From->To", p.Pair)
+	}
+	return [2]string{strings.TrimSpace(from), strings.TrimSpace(to)}, nil
+}
+
+// order picks the order a step acts on, from the cache or the journey state
+func (p StepParams) order(q *Query, st *JourneyState, states []int) (string, string, error) {
+	switch p.Order {
+	case "current":
+		if st.OrderID == "" {
+			return "", "", fmt.Errorf("no current order")
+		}
+		return st.OrderID, st.TripID, nil
+	case "", "cache":
+		highSpeed := p.highSpeed(q, st)
+		pairs, err := q.QueryOrders(states, !highSpeed)
+		if err != nil {
+			return "", "", err
+		}
+		if len(pairs) == 0 {
+			return "", "", fmt.Errorf("no cached orders in states %v", states)
+		}
+		pair := RandomFromList(q.Rand, pairs).([2]string)
+		st.HighSpeed = highSpeed
+		st.OrderID, st.TripID = pair[0], pair[1]
+		return pair[0], pair[1], nil
+	default:
+		return "", "", fmt.Errorf("unknown order source %q", p.Order)
+	}
+}
+
+func stepSearch(q *Query, st *JourneyState, p StepParams) error {
+	highSpeed := p.highSpeed(q, st)
+	pair, err := p.pair(q, highSpeed)
+	if err != nil {
+		return err
+	}
+	date := Dates.Next(q.Rand)
+
+	var tripIDs []string
+	var tripDate string
+	if highSpeed {
+		tripIDs, tripDate, err = q.QueryHighSpeedTicket(pair, date)
+	} else {
+		tripIDs, tripDate, err = q.QueryNormalTicket(pair, date)
+	}
+	if err != nil {
+		return err
+	}
+
+	st.HighSpeed, st.Pair, st.Date = highSpeed, pair, date
+	st.TripIDs, st.TripDate = tripIDs, tripDate
+
+	if len(tripIDs) == 0 {
+		return fmt.Errorf("no trips available from %s to %s on %s", pair[0], pair[1], date.Format("2006-01-02"))
+	}
+
+	log.Printf("Found %d trips from %s to %s on %s", len(tripIDs), pair[0], pair[1], tripDate)
+	return nil
+}
+
+func stepSearchParallel(q *Query, st *JourneyState, p StepParams) error {
+	pair, err := p.pair(q, true)
+	if err != nil {
+		return err
+	}
+	date := Dates.Next(q.Rand)
+
+	tripIDs, err := q.QueryHighSpeedTicketParallel(pair, date)
+	if err != nil {
+		return err
+	}
+
+	st.HighSpeed, st.Pair, st.Date = true, pair, date
+	st.TripIDs, st.TripDate = tripIDs, date.Format("2006-01-02")
+
+	if len(tripIDs) == 0 {
+		return fmt.Errorf("no trips available from %s to %s on %s", pair[0], pair[1], st.TripDate)
+	}
+
+	log.Printf("Found %d trips in parallel from %s to %s on %s", len(tripIDs), pair[0], pair[1], st.TripDate)
+	return nil
+}
+
+func stepPlan(q *Query, st *JourneyState, p StepParams) error {
+	highSpeed := p.highSpeed(q, st)
+	pair, err := p.pair(q, highSpeed)
+	if err != nil {
+		return err
+	}
+	date := Dates.Next(q.Rand)
+
+	planType := p.PlanType
+	if planType == "" || planType == "random" {
+		planType = RandomFromList(q.Rand, PlanTypes).(string)
+	}
+
+	plans, err := q.QueryTravelPlan(planType, pair, date)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Found %d %s travel plans from %s to %s", len(plans), planType, pair[0], pair[1])
+	return nil
+}
+
+func stepPreserve(q *Query, st *JourneyState, p StepParams) error {
+	if len(st.TripIDs) == 0 {
+		return fmt.Errorf("preserve needs a successful search first")
+	}
+
+	booking, err := q.Preserve(st.Pair[0], st.Pair[1], st.TripIDs, st.HighSpeed, st.TripDate)
+	if err != nil {
+		return err
+	}
+
+	st.Booking = booking
+	return nil
+}
+
+// stepLocate finds and claims the order the last preserve created and makes
+// it the current order. It fails if the order can't be told apart from other
+// bookings of the account, rather than continuing with someone else's order.
+func stepLocate(q *Query, st *JourneyState, p StepParams) error {
+	if st.Booking == nil {
+		return fmt.Errorf("locate needs a successful preserve first")
+	}
+
+	order, err := q.LocateOrder(st.Booking)
+	if err != nil {
+		return err
+	}
+
+	st.Order = order
+	st.OrderID, st.TripID = order.ID, order.TrainNumber
+	st.claims = append(st.claims, claim{order.ID, st.Booking})
+	log.Printf("Located order %s of trip %s", order.ID, order.TrainNumber)
+	return nil
+}
+
+func stepPay(q *Query, st *JourneyState, p StepParams) error {
+	orderID, tripID, err := p.order(q, st, []int{0})
+	if err != nil {
+		return err
+	}
+	return q.PayOrder(orderID, tripID)
+}
+
+func stepCancel(q *Query, st *JourneyState, p StepParams) error {
+	orderID, _, err := p.order(q, st, []int{0, 1})
+	if err != nil {
+		return err
+	}
+	return q.CancelOrder(orderID, q.UID)
+}
+
+func stepCollect(q *Query, st *JourneyState, p StepParams) error {
+	orderID, _, err := p.order(q, st, []int{1})
+	if err != nil {
+		return err
+	}
+	return q.CollectTicket(orderID)
+}
+
+func stepEnter(q *Query, st *JourneyState, p StepParams) error {
+	orderID, _, err := p.order(q, st, []int{2})
+	if err != nil {
+		return err
+	}
+	return q.EnterStation(orderID)
+}
+
+func stepConsign(q *Query, st *JourneyState, p StepParams) error {
+	orderID, _, err := p.order(q, st, []int{0, 1, 2})
+	if err != nil {
+		return err
+	}
+
+	if st.Order != nil && st.Order.ID == orderID {
+		return q.PutConsign(map[string]interface{}{
+			"accountId": st.Order.AccountID,
+			"orderId":   st.Order.ID,
+			"from":      st.Order.From,
+			"to":        st.Order.To,
+		})
+	}
+
+	orders, err := q.QueryOrdersAllInfo(!st.HighSpeed)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if id, _ := order["orderId"].(string); id == orderID {
+			return q.PutConsign(order)
+		}
+	}
+
+	return fmt.Errorf("order %s not found in cache", orderID)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Think times applied between the steps of a scenario and between scenarios
+// (or sessions) of a worker. Together with the number of workers they set
+// the user population a closed-loop run models.
+var (
+	StepThink     ThinkTime
+	ScenarioThink ThinkTime
+)
+
+// ThinkTime is a distribution of pauses a simulated user takes
+type ThinkTime struct {
+	// Dist is "fixed", "uniform", "exponential" or "lognormal"; empty means no pause
+	Dist string `yaml:"dist"`
+	// Value is the pause of the fixed distribution
+	Value time.Duration `yaml:"value"`
+	// Min and Max bound the uniform distribution
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+	// Mean is the mean of the exponential distribution
+	Mean time.Duration `yaml:"mean"`
+	// Median and Sigma parameterise the lognormal distribution
+	Median time.Duration `yaml:"median"`
+	Sigma  float64       `yaml:"sigma"`
+}
+
+// ParseThinkTime parses "none", "<duration>", "fixed:<d>", "uniform:<min>-<max>",
+// "exp:<mean>" or "lognormal:<median>,<sigma>"
+func ParseThinkTime(spec string) (ThinkTime, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return ThinkTime{}, nil
+	}
+
+	kind, arg, hasArg := strings.Cut(spec, ":")
+	if !hasArg {
+		kind, arg = "fixed", spec // A bare duration is a fixed pause
+	}
+
+	var t ThinkTime
+	var err error
+	switch kind {
+	case "fixed":
+		t.Dist = "fixed"
+		t.Value, err = time.ParseDuration(arg)
+	case "uniform":
+		minStr, maxStr, ok := strings.Cut(arg, "-")
+		if !ok {
+			return ThinkTime{}, fmt.Errorf("uniform think time needs <min>-<max>")
+		}
+		t.Dist = "uniform"
+		if t.Min, err = time.ParseDuration(minStr); err == nil {
+			t.Max, err = time.ParseDuration(maxStr)
+		}
+	case "exp", "exponential":
+		t.Dist = "exponential"
+		t.Mean, err = time.ParseDuration(arg)
+	case "lognormal":
+		medianStr, sigmaStr, ok := strings.Cut(arg, ",")
+		if !ok {
+			return ThinkTime{}, fmt.Errorf("lognormal think time needs <median>,<sigma>")
+		}
+		t.Dist = "lognormal"
+		if t.Median, err = time.ParseDuration(medianStr); err == nil {
+			t.Sigma, err = strconv.ParseFloat(sigmaStr, 64)
+		}
+	default:
+		return ThinkTime{}, fmt.Errorf("unknown think time distribution %q", kind)
+	}
+
+	if err != nil {
+		return ThinkTime{}, fmt.Errorf("invalid think time %q: %v", spec, err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return ThinkTime{}, err
+	}
+
+	return t, nil
+}
+
+// UnmarshalYAML accepts both the spec string of ParseThinkTime and the
+// expanded form with dist, value, min, max, mean, median and sigma
+func (t *ThinkTime) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		parsed, err := ParseThinkTime(node.Value)
+		if err != nil {
+			return err
+		}
+		*t = parsed
+		return nil
+	}
+
+	type plain ThinkTime
+	return node.Decode((*plain)(t))
+}
+
+func (t ThinkTime) Validate() error {
+	switch t.Dist {
+	case "", "none":
+	case "fixed":
+		if t.Value < 0 {
+			return fmt.Errorf("fixed think time must not be negative")
+		}
+	case "uniform":
+		if t.Min < 0 || t.Max < t.Min {
+			return fmt.Errorf("uniform think time needs 0 <= min <= max")
+		}
+	case "exponential":
+		if t.Mean <= 0 {
+			return fmt.Errorf("exponential think time needs a positive mean")
+		}
+	case "lognormal":
+		if t.Median <= 0 || t.Sigma < 0 {
+			return fmt.Errorf("lognormal think time needs a positive median and a non-negative sigma")
+		}
+	default:
+		return fmt.Errorf("unknown think time distribution %q", t.Dist)
+	}
+	return nil
+}
+
+// Sample draws one pause from the distribution
+func (t ThinkTime) Sample(r *rand.Rand) time.Duration {
+	switch t.Dist {
+	case "fixed":
+		return t.Value
+	case "uniform":
+		return t.Min + time.Duration(r.Int63n(int64(t.Max-t.Min)+1))
+	case "exponential":
+		return time.Duration(r.ExpFloat64() * float64(t.Mean))
+	case "lognormal":
+		return time.Duration(float64(t.Median) * math.Exp(t.Sigma*r.NormFloat64()))
+	default:
+		return 0
+	}
+}
+
+// MeanDuration is the expected pause of the distribution
+func (t ThinkTime) MeanDuration() time.Duration {
+	switch t.Dist {
+	case "fixed":
+		return t.Value
+	case "uniform":
+		return (t.Min + t.Max) / 2
+	case "exponential":
+		return t.Mean
+	case "lognormal":
+		return time.Duration(float64(t.Median) * math.Exp(t.Sigma*t.Sigma/2))
+	default:
+		return 0
+	}
+}
+
+func (t ThinkTime) String() string {
+	switch t.Dist {
+	case "fixed":
+		return fmt.Sprintf("fixed %v", t.Value)
+	case "uniform":
+		return fmt.Sprintf("uniform %v-%v", t.Min, t.Max)
+	case "exponential":
+		return fmt.Sprintf("exponential, mean %v", t.Mean)
+	case "lognormal":
+		return fmt.Sprintf("lognormal, median %v, sigma %.2f (mean %v)", t.Median, t.Sigma, t.MeanDuration())
+	default:
+		return "none"
+	}
+}
+
+// StepPause pauses between two steps of a scenario
+func StepPause(q *Query) {
+	q.Think(StepThink.Sample(q.Rand))
+}
+
+// Think pauses for d on behalf of the simulated user, or until q.Stop closes,
+// and reports false in the latter case. The pause is added to Thinking, so
+// timings around it can exclude it.
+func (q *Query) Think(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	log.Printf("Thinking for %v", d)
+	start := time.Now()
+	ok := sleepOrStop(d, q.Stop)
+	q.thinking += time.Since(start)
+	return ok
+}
+
+// Thinking is the total time q spent in Think. The latency of a call that
+// may think is its duration minus the growth of Thinking meanwhile.
+func (q *Query) Thinking() time.Duration {
+	return q.thinking
+}
+
+// sleepOrStop pauses for d and reports false if stopChan closed meanwhile
+func sleepOrStop(d time.Duration, stopChan <-chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-stopChan:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
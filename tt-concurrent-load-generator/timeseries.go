@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// series is the time-series output of the running load test, nil if none
+var series *TimeSeries
+
+// TimeSeries writes a CSV file with one "sample" row per interval, holding
+// the scenarios completed in it and their mean latency, and one "event" row
+// per noteworthy change during the run, such as a burst parameter change
+type TimeSeries struct {
+	mu        sync.Mutex
+	file      *os.File
+	w         *csv.Writer
+	start     time.Time
+	interval  time.Duration
+	completed int
+	latency   time.Duration
+}
+
+func NewTimeSeries(path string, interval time.Duration) (*TimeSeries, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("time-series interval must be positive")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create time-series file: %v", err)
+	}
+
+	t := &TimeSeries{file: f, w: csv.NewWriter(f), start: time.Now(), interval: interval}
+	t.w.Write([]string{"time", "elapsed_s", "kind", "scenarios", "throughput_per_s", "mean_latency_ms", "event"})
+	return t, nil
+}
+
+// RecordScenario counts one completed scenario
+func (t *TimeSeries) RecordScenario(latency time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.completed++
+	t.latency += latency
+}
+
+// Event writes an event row right away
+func (t *TimeSeries) Event(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.w.Write([]string{now.Format(time.RFC3339Nano), seconds(now.Sub(t.start)), "event", "", "", "", fmt.Sprintf(format, args...)})
+	t.w.Flush()
+}
+
+// Run writes a sample row every interval until stopChan closes
+func (t *TimeSeries) Run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			t.sample()
+			return
+		case <-ticker.C:
+			t.sample()
+		}
+	}
+}
+
+func (t *TimeSeries) sample() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	mean := 0.0
+	if t.completed > 0 {
+		mean = ms(t.latency / time.Duration(t.completed))
+	}
+
+	now := time.Now()
+	t.w.Write([]string{
+		now.Format(time.RFC3339Nano),
+		seconds(now.Sub(t.start)),
+		"sample",
+		strconv.Itoa(t.completed),
+		strconv.FormatFloat(float64(t.completed)/t.interval.Seconds(), 'f', 2, 64),
+		strconv.FormatFloat(mean, 'f', 1, 64),
+		"",
+	})
+	t.w.Flush()
+
+	t.completed, t.latency = 0, 0
+}
+
+func (t *TimeSeries) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.w.Flush()
+	if err := t.w.Error(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -2,40 +2,73 @@ package main
 
 import (
     "math/rand"
+    "sort"
     "time"
 )
 
-func init() {
-    rand.Seed(time.Now().UnixNano())
+// Seed is the base seed for every random stream of a run. Each worker
+// derives its own stream from it, so the same seed reproduces the same
+// scenario sequence and parameter choices.
+var Seed int64
+
+// InitSeed sets the run seed, falling back to the current time when seed is 0.
+func InitSeed(seed int64) {
+    if seed == 0 {
+        seed = time.Now().UnixNano()
+    }
+    Seed = seed
+}
+
+// Random streams not owned by a worker, which use their ID from 0 upwards
+const (
+    // StreamDataFetch is the stream of the order cache refresh
+    StreamDataFetch int64 = -1
+    // StreamShared is the default stream of a new Query
+    StreamShared int64 = -2
+)
+
+// NewRand returns a random source for the given stream (usually a worker ID)
+// derived from the run seed. Seed and stream are hashed together, so
+// adjacent seeds do not reproduce each other's streams shifted by one.
+func NewRand(stream int64) *rand.Rand {
+    return rand.New(rand.NewSource(int64(splitmix64(splitmix64(uint64(Seed)) ^ uint64(stream)))))
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator, a bijective hash
+func splitmix64(x uint64) uint64 {
+    x += 0x9e3779b97f4a7c15
+    x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+    x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+    return x ^ (x >> 31)
 }
 
-func RandomBoolean() bool {
-    return rand.Intn(2) == 1
+func RandomBoolean(r *rand.Rand) bool {
+    return r.Intn(2) == 1
 }
 
 
-func RandomFromList(list interface{}) interface{} {
+func RandomFromList(r *rand.Rand, list interface{}) interface{} {
     switch v := list.(type) {
     case []string:
         if len(v) == 0 {
             return ""
         }
-        return v[rand.Intn(len(v))]
+        return v[r.Intn(len(v))]
     case [][2]string:
         if len(v) == 0 {
             return [2]string{"", ""}
         }
-        return v[rand.Intn(len(v))]
+        return v[r.Intn(len(v))]
     case []map[string]interface{}:
         if len(v) == 0 {
             return map[string]interface{}{}
         }
-        return v[rand.Intn(len(v))]
+        return v[r.Intn(len(v))]
     case []interface{}:
         if len(v) == 0 {
             return nil
         }
-        return v[rand.Intn(len(v))]
+        return v[r.Intn(len(v))]
     case map[string]interface{}:
         if len(v) == 0 {
             return nil
@@ -44,42 +77,47 @@ func RandomFromList(list interface{}) interface{} {
         for k := range v {
             keys = append(keys, k)
         }
-        randomKey := keys[rand.Intn(len(keys))]
+        sort.Strings(keys) // map order is random, keep the pick reproducible
+        randomKey := keys[r.Intn(len(keys))]
         return v[randomKey]
     default:
         return nil
     }
 }
 
-func RandomFromWeighted(weights map[bool]int) bool {
+func RandomFromWeighted(r *rand.Rand, weights map[bool]int) bool {
     total := 0
     for _, weight := range weights {
         total += weight
     }
-    r := rand.Intn(total)
-    for k, weight := range weights {
-        r -= weight
-        if r <= 0 {
+    if total <= 0 {
+        return false
+    }
+    n := r.Intn(total)
+    // Walk the keys in a fixed order, map iteration order is random
+    for _, k := range []bool{true, false} {
+        n -= weights[k]
+        if n < 0 {
             return k
         }
     }
     return false
 }
 
-func RandomString(n int) string {
+func RandomString(r *rand.Rand, n int) string {
     const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
     b := make([]byte, n)
     for i := range b {
-        b[i] = letters[rand.Intn(len(letters))]
+        b[i] = letters[r.Intn(len(letters))]
     }
     return string(b)
 }
 
-func RandomPhone() string {
+func RandomPhone(r *rand.Rand) string {
     const digits = "0123456789"
-    b := make([]byte, rand.Intn(8)+8)
+    b := make([]byte, r.Intn(8)+8)
     for i := range b {
-        b[i] = digits[rand.Intn(len(digits))]
+        b[i] = digits[r.Intn(len(digits))]
     }
     return string(b)
-}
\ No newline at end of file
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// VerifyTolerance is the relative deviation from a warmup target that
+// verification still accepts
+var VerifyTolerance = 0.05
+
+// verifyKey groups orders in the verification table
+type verifyKey struct {
+	HighSpeed bool
+	Status    int
+	Route     string
+	Date      string
+}
+
+// VerifyDatabase reads the orders of both order services and the
+// consignments of the account of q, and reports them per status, route and
+// travel day, followed by the number of orders per warmup state against
+// targets. It returns the report and whether every state is within
+// tolerance of its target.
+func VerifyDatabase(q *Query, targets map[string]int, tolerance float64) (string, bool, error) {
+	consigns, err := q.QueryConsignsByAccount(q.UID)
+	if err != nil {
+		return "", false, err
+	}
+	consigned := make(map[string]bool, len(consigns))
+	for _, c := range consigns {
+		consigned[c.OrderID] = true
+	}
+
+	groups := make(map[verifyKey]int)
+	stateCounts := make([]int, len(warmupStates))
+	for _, highSpeed := range []bool{true, false} {
+		orders, err := q.RefreshOrders(!highSpeed)
+		if err != nil {
+			return "", false, err
+		}
+
+		for _, order := range orders {
+			key := verifyKey{
+				HighSpeed: highSpeed,
+				Status:    order.Status,
+				Route:     Catalog.StationName(order.From) + " -> " + Catalog.StationName(order.To),
+				Date:      order.Day(),
+			}
+			groups[key]++
+
+			if i := warmupStateOf(order, consigned); i >= 0 {
+				stateCounts[i]++
+			}
+		}
+	}
+
+	keys := make([]verifyKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.HighSpeed != b.HighSpeed {
+			return a.HighSpeed
+		}
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		return a.Date < b.Date
+	})
+
+	result := "\nOrders per status, route and travel day:\n"
+	result += fmt.Sprintf("  %-10s %-10s %-36s %-10s %6s\n", "Family", "Status", "Route", "Date", "Count")
+	for _, key := range keys {
+		family := "normal"
+		if key.HighSpeed {
+			family = "high-speed"
+		}
+		result += fmt.Sprintf("  %-10s %-10s %-36s %-10s %6d\n",
+			family, OrderStatusName(key.Status), key.Route, key.Date, groups[key])
+	}
+
+	ok := true
+	result += fmt.Sprintf("\nOrders per warmup state (tolerance %.0f%%):\n", 100*tolerance)
+	result += fmt.Sprintf("  %-12s %8s %8s %9s\n", "State", "Actual", "Target", "")
+	for i, state := range warmupStates {
+		target, hasTarget := targets[state.name]
+		status := ""
+		if hasTarget {
+			status = "ok"
+			if math.Abs(float64(stateCounts[i]-target)) > tolerance*float64(target) {
+				status = "MISMATCH"
+				ok = false
+			}
+		}
+		result += fmt.Sprintf("  %-12s %8d %8s %9s\n", state.name, stateCounts[i], formatTarget(target, hasTarget), status)
+	}
+
+	result += fmt.Sprintf("  (%d consignments for %d orders)\n", len(consigns), len(consigned))
+
+	return result, ok, nil
+}
+
+func formatTarget(target int, hasTarget bool) string {
+	if !hasTarget {
+		return "-"
+	}
+	return fmt.Sprint(target)
+}
+
+// runVerify logs the verification report of the database behind url and
+// exits with status 1 if it is out of tolerance
+func runVerify(url string) {
+	report, ok, err := VerifyDatabase(login(url), WarmupTargets, VerifyTolerance)
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+	log.Println(report)
+
+	if !ok {
+		log.Println("Verification failed: order counts are out of tolerance")
+		annotations.Close("verification failed")
+		os.Exit(1)
+	}
+	log.Println("Verification passed")
+}
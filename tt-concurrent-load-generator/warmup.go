@@ -1,62 +1,326 @@
 package main
 
 import (
+    "errors"
     "log"
     "fmt"
+    "os"
+    "strconv"
+    "strings"
     "time"
     "sync"
     "sync/atomic"
+
+    "gopkg.in/yaml.v3"
 )
 
-type WarmupCounter struct {
-    unpaidCount    int32
-    paidCount      int32
-    collectedCount int32
-    consignedCount int32
-    mu            sync.Mutex
+// warmupState is an order state warmup can populate and how to reach it
+type warmupState struct {
+    name   string
+    create func(q *Query, highSpeed bool) error
+}
+
+// warmupUnpaid is the index of the unpaid state in warmupStates
+const warmupUnpaid = 0
+
+// warmupStates lists the states in the order workers fill their quotas
+var warmupStates = []warmupState{
+    {"unpaid", createUnpaidOrder},
+    {"paid", createPaidOrder},
+    {"collected", createCollectedOrder},
+    {"executed", createExecutedOrder},
+    {"consigned", createConsignedOrder},
+    {"cancelled", createCancelledOrder},
+}
+
+// WarmupTargets is the number of orders per state the account should have
+// after warmup, across both service families, as verification checks them
+var WarmupTargets = map[string]int{
+    "unpaid":    1000,
+    "paid":      500,
+    "collected": 500,
+    "consigned": 500,
+}
+
+func warmupStateNames() string {
+    names := make([]string, len(warmupStates))
+    for i, state := range warmupStates {
+        names[i] = state.name
+    }
+    return strings.Join(names, ", ")
+}
+
+// ParseWarmupTargets parses "state=count,..." or reads the same mapping from
+// a YAML or JSON file, such as {unpaid: 1000, paid: 500, cancelled: 100}.
+// States left out get no orders.
+func ParseWarmupTargets(spec string) (map[string]int, error) {
+    targets := make(map[string]int)
+
+    if !strings.Contains(spec, "=") {
+        data, err := os.ReadFile(spec)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read warmup targets: %v", err)
+        }
+        if err := yaml.Unmarshal(data, &targets); err != nil {
+            return nil, fmt.Errorf("failed to parse warmup targets: %v", err)
+        }
+    } else {
+        for _, token := range strings.Split(spec, ",") {
+            token = strings.TrimSpace(token)
+            if token == "" {
+                continue
+            }
+            name, countStr, _ := strings.Cut(token, "=")
+            count, err := strconv.Atoi(strings.TrimSpace(countStr))
+            if err != nil {
+                return nil, fmt.Errorf("invalid count in %q", token)
+            }
+            targets[strings.TrimSpace(name)] = count
+        }
+    }
+
+    for name, count := range targets {
+        if warmupStateIndex(name) < 0 {
+            return nil, fmt.Errorf("unknown warmup state %q (known: %s)", name, warmupStateNames())
+        }
+        if count < 0 {
+            return nil, fmt.Errorf("negative target for %s", name)
+        }
+    }
+
+    return targets, nil
 }
 
-func NewWarmupCounter() *WarmupCounter {
-    return &WarmupCounter{}
+func warmupStateIndex(name string) int {
+    for i, state := range warmupStates {
+        if state.name == name {
+            return i
+        }
+    }
+    return -1
 }
 
-func (w *WarmupCounter) canCreateUnpaid() bool {
-    return atomic.LoadInt32(&w.unpaidCount) < 1000
+// warmupQuota counts the orders of one service family created per state
+// (indexed like warmupStates) against the targets of that family
+type warmupQuota struct {
+    name    string
+    counts  []int32
+    targets []int32
 }
 
-func (w *WarmupCounter) canCreatePaid() bool {
-    return atomic.LoadInt32(&w.paidCount) < 500
+func (w *warmupQuota) canCreate(state int) bool {
+    return atomic.LoadInt32(&w.counts[state]) < w.targets[state]
 }
 
-func (w *WarmupCounter) canCreateCollected() bool {
-    return atomic.LoadInt32(&w.collectedCount) < 500
+// nextState returns the first state below its target, or -1
+func (w *warmupQuota) nextState() int {
+    for i := range w.counts {
+        if w.canCreate(i) {
+            return i
+        }
+    }
+    return -1
 }
 
-func (w *WarmupCounter) canCreateConsigned() bool {
-    return atomic.LoadInt32(&w.consignedCount) < 500
+func (w *warmupQuota) getTotalCount() int32 {
+    var total int32
+    for i := range w.counts {
+        total += atomic.LoadInt32(&w.counts[i])
+    }
+    return total
+}
+
+func (w *warmupQuota) getTotalTarget() int32 {
+    var total int32
+    for _, target := range w.targets {
+        total += target
+    }
+    return total
 }
 
-func (w *WarmupCounter) incrementUnpaid() {
-    atomic.AddInt32(&w.unpaidCount, 1)
+// WarmupCounter tracks the high-speed (order service) and normal-train
+// (order-other service) quotas separately, so both databases get populated.
+// targets holds the account-wide target of every state.
+type WarmupCounter struct {
+    highSpeed warmupQuota
+    normal    warmupQuota
+    targets   []int32
 }
 
-func (w *WarmupCounter) incrementPaid() {
-    atomic.AddInt32(&w.paidCount, 1)
+// NewWarmupCounter splits the targets of every state between the two
+// service families by highspeedWeights
+func NewWarmupCounter(targets map[string]int) *WarmupCounter {
+    w := &WarmupCounter{
+        highSpeed: warmupQuota{name: "high-speed"},
+        normal:    warmupQuota{name: "normal"},
+    }
+    for _, state := range warmupStates {
+        w.highSpeed.counts = append(w.highSpeed.counts, 0)
+        w.highSpeed.targets = append(w.highSpeed.targets, 0)
+        w.normal.counts = append(w.normal.counts, 0)
+        w.normal.targets = append(w.normal.targets, 0)
+        w.targets = append(w.targets, int32(targets[state.name]))
+    }
+    w.spreadDeficits()
+    return w
 }
 
-func (w *WarmupCounter) incrementCollected() {
-    atomic.AddInt32(&w.collectedCount, 1)
+// spreadDeficits sets the target of each family to its current count plus
+// its share, by highspeedWeights, of what the state still lacks across both
+// families. Existing orders of one family thus reduce what the other family
+// gets, and the account-wide total meets the target of the state.
+func (w *WarmupCounter) spreadDeficits() {
+    total := int32(highspeedWeights[true] + highspeedWeights[false])
+
+    for i, target := range w.targets {
+        hsCount, normalCount := w.highSpeed.counts[i], w.normal.counts[i]
+        deficit := target - hsCount - normalCount
+        if deficit < 0 {
+            deficit = 0
+        }
+        hs := int32(0)
+        if total > 0 {
+            hs = deficit * int32(highspeedWeights[true]) / total
+        }
+        w.highSpeed.targets[i] = hsCount + hs
+        w.normal.targets[i] = normalCount + deficit - hs
+    }
 }
 
-func (w *WarmupCounter) incrementConsigned() {
-    atomic.AddInt32(&w.consignedCount, 1)
+// activeWarmup is the counter of the running warmup, nil outside warmup
+var activeWarmup *WarmupCounter
+
+// countStray counts an unpaid order warmup created without following it,
+// such as one of several orders an ambiguous booking matched
+func (w *WarmupCounter) countStray(highSpeed bool) {
+    if w == nil {
+        return
+    }
+    atomic.AddInt32(&w.quota(highSpeed).counts[warmupUnpaid], 1)
+}
+
+// quota returns the counters of a service family
+func (w *WarmupCounter) quota(highSpeed bool) *warmupQuota {
+    if highSpeed {
+        return &w.highSpeed
+    }
+    return &w.normal
 }
 
 func (w *WarmupCounter) getTotalCount() int32 {
-    return atomic.LoadInt32(&w.unpaidCount) + 
-           atomic.LoadInt32(&w.paidCount) + 
-           atomic.LoadInt32(&w.collectedCount) + 
-           atomic.LoadInt32(&w.consignedCount)
+    return w.highSpeed.getTotalCount() + w.normal.getTotalCount()
+}
+
+// nextFamily picks the service family of the next order by highspeedWeights,
+// among the families whose quotas are not met yet
+func (w *WarmupCounter) nextFamily(q *Query) (bool, bool) {
+    hsOpen, normalOpen := w.highSpeed.nextState() >= 0, w.normal.nextState() >= 0
+    switch {
+    case hsOpen && normalOpen:
+        return RandomFromWeighted(q.Rand, highspeedWeights), true
+    case hsOpen:
+        return true, true
+    case normalOpen:
+        return false, true
+    default:
+        return false, false
+    }
+}
+
+// Summary reports the created orders against the targets, per service
+// family and state
+func (w *WarmupCounter) Summary() string {
+    result := fmt.Sprintf("  %-12s %14s %14s %14s\n", "State", "high-speed", "normal", "total")
+    cell := func(count, target int32) string {
+        return fmt.Sprintf("%d/%d", count, target)
+    }
+
+    for i, state := range warmupStates {
+        hs, normal := &w.highSpeed, &w.normal
+        if hs.targets[i]+normal.targets[i] == 0 && hs.counts[i]+normal.counts[i] == 0 {
+            continue
+        }
+        result += fmt.Sprintf("  %-12s %14s %14s %14s\n", state.name,
+            cell(hs.counts[i], hs.targets[i]),
+            cell(normal.counts[i], normal.targets[i]),
+            cell(hs.counts[i]+normal.counts[i], w.targets[i]))
+    }
+
+    var totalTarget int32
+    for _, target := range w.targets {
+        totalTarget += target
+    }
+    result += fmt.Sprintf("  %-12s %14s %14s %14s\n", "total",
+        cell(w.highSpeed.getTotalCount(), w.highSpeed.getTotalTarget()),
+        cell(w.normal.getTotalCount(), w.normal.getTotalTarget()),
+        cell(w.getTotalCount(), totalTarget))
+    return result
+}
+
+// warmupStateOf maps an existing order to the index of the warmup state it
+// counts for, or -1. Consigned orders count as consigned whatever their status.
+func warmupStateOf(o Order, consigned map[string]bool) int {
+    if consigned[o.ID] {
+        return warmupStateIndex("consigned")
+    }
+
+    switch o.Status {
+    case OrderNotPaid:
+        return warmupStateIndex("unpaid")
+    case OrderPaid:
+        return warmupStateIndex("paid")
+    case OrderCollected:
+        return warmupStateIndex("collected")
+    case OrderUsed:
+        return warmupStateIndex("executed")
+    case OrderCancel:
+        return warmupStateIndex("cancelled")
+    }
+    return -1
+}
+
+// Resume starts the counters at the orders the account of q already has,
+// read through the refresh endpoints of both order services, and spreads
+// only the remaining deficit of every state across the families. Warmup
+// thus converges on the same account-wide totals per state that
+// VerifyDatabase checks, however the existing orders are distributed.
+func (w *WarmupCounter) Resume(q *Query) error {
+    consigns, err := q.QueryConsignsByAccount(q.UID)
+    if err != nil {
+        return err
+    }
+    consigned := make(map[string]bool, len(consigns))
+    for _, c := range consigns {
+        consigned[c.OrderID] = true
+    }
+
+    for _, highSpeed := range []bool{true, false} {
+        orders, err := q.RefreshOrders(!highSpeed)
+        if err != nil {
+            return err
+        }
+
+        quota := w.quota(highSpeed)
+        for _, order := range orders {
+            if i := warmupStateOf(order, consigned); i >= 0 {
+                quota.counts[i]++
+            }
+        }
+    }
+
+    w.spreadDeficits()
+    return nil
+}
+
+// formatWarmupTargets lists the targets in state order
+func formatWarmupTargets(targets map[string]int) string {
+    parts := make([]string, 0, len(targets))
+    for _, state := range warmupStates {
+        if count, ok := targets[state.name]; ok {
+            parts = append(parts, fmt.Sprintf("%s=%d", state.name, count))
+        }
+    }
+    return strings.Join(parts, ",")
 }
 
 func WarmupWorker(id int, url string, wg *sync.WaitGroup, counter *WarmupCounter) {
@@ -77,141 +341,262 @@ func WarmupWorker(id int, url string, wg *sync.WaitGroup, counter *WarmupCounter
         log.Printf("Worker %d: Login successful", id)
         break
     }
+    q.Rand = NewRand(int64(id))
     
     if retryCount == maxRetries {
         log.Printf("Worker %d: Failed to login after %d attempts", id, maxRetries)
         return
     }
 
+    contact, err := warmupContact(q, id)
+    if err != nil {
+        log.Printf("Worker %d: Failed to set up its contact: %v", id, err)
+        return
+    }
+    q.Contact = contact
+
     for {
-        total := counter.getTotalCount()
-        if total >= 5000 {
-            log.Printf("Worker %d: Target count reached, exiting", id)
+        highSpeed, ok := counter.nextFamily(q)
+        if !ok {
+            log.Printf("Worker %d: All quotas met, exiting", id)
             return
         }
+        quota := counter.quota(highSpeed)
+        i := quota.nextState()
+        if i < 0 {
+            continue // Another worker met the quota meanwhile
+        }
+        state := warmupStates[i]
+
+        err := state.create(q, highSpeed)
+        if err != nil {
+            log.Printf("Worker %d: Error creating %s %s order: %v", id, state.name, quota.name, err)
+            // Add a small delay before retrying
+            time.Sleep(time.Millisecond * 100)
+            continue
+        }
 
-        UpdateBaseDate() // Update BaseDate to a new random date
+        count := atomic.AddInt32(&quota.counts[i], 1)
+        log.Printf("Worker %d: Created %s %s order. Total %s: %d/%d",
+            id, state.name, quota.name, state.name, count, quota.targets[i])
+    }
+}
 
-        var err error
-        switch {
-        case counter.canCreateUnpaid():
-            err = createUnpaidOrder(q)
-            if err == nil {
-                counter.incrementUnpaid()
-                log.Printf("Worker %d: Created unpaid order. Total unpaid: %d/1000", 
-                    id, atomic.LoadInt32(&counter.unpaidCount))
-            }
+// bookUnpaidOrder books a trip and returns the booking with the ID of the
+// order it created
+func bookUnpaidOrder(q *Query, highSpeed bool) (*Booking, error) {
+    pair := Catalog.Sample(q.Rand, highSpeed)
+    start, end := pair[0], pair[1]
+    var tripIDs []string
+    var tripDate string
+    var err error
+    if highSpeed {
+        tripIDs, tripDate, err = q.QueryHighSpeedTicket([2]string{start, end}, Dates.Next(q.Rand))
+    } else {
+        tripIDs, tripDate, err = q.QueryNormalTicket([2]string{start, end}, Dates.Next(q.Rand))
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to query ticket: %v", err)
+    }
+    if len(tripIDs) == 0 {
+        return nil, fmt.Errorf("no trips available")
+    }
 
-        case counter.canCreatePaid():
-            err = createPaidOrder(q)
-            if err == nil {
-                counter.incrementPaid()
-                log.Printf("Worker %d: Created paid order. Total paid: %d/500", 
-                    id, atomic.LoadInt32(&counter.paidCount))
-            }
+    booking, err := q.Preserve(start, end, tripIDs, highSpeed, tripDate)
+    if err != nil {
+        return nil, err
+    }
 
-        case counter.canCreateCollected():
-            err = createCollectedOrder(q)
-            if err == nil {
-                counter.incrementCollected()
-                log.Printf("Worker %d: Created collected order. Total collected: %d/500", 
-                    id, atomic.LoadInt32(&counter.collectedCount))
-            }
+    // Claims the order against concurrent workers of the same account and
+    // also confirms the order was stored unpaid
+    order, err := q.LocateOrder(booking)
+    var ambiguous *AmbiguousOrderError
+    if errors.As(err, &ambiguous) {
+        order, err = adoptStrayOrders(ambiguous, highSpeed)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to locate booked order: %v", err)
+    }
+    if order.Status != OrderNotPaid {
+        return nil, fmt.Errorf("booked order %s has status %d, expected %d", order.ID, order.Status, OrderNotPaid)
+    }
 
-        case counter.canCreateConsigned():
-            err = createConsignedOrder(q)
-            if err == nil {
-                counter.incrementConsigned()
-                log.Printf("Worker %d: Created consigned order. Total consigned: %d/500", 
-                    id, atomic.LoadInt32(&counter.consignedCount))
-            }
+    booking.OrderID = order.ID
+    return booking, nil
+}
 
-        default:
-            log.Printf("Worker %d: All quotas met", id)
-            return
+// warmupContact returns the contact worker id books for, adding it on first
+// use. With a contact per worker, the bookings of concurrent workers never
+// match each other's orders in LocateOrder.
+func warmupContact(q *Query, id int) (*Contact, error) {
+    document := fmt.Sprintf("WARMUP%04d", id)
+
+    contacts, err := q.QueryContacts()
+    if err != nil {
+        return nil, err
+    }
+    for i := range contacts {
+        if contacts[i].DocumentType == DocumentIDCard && contacts[i].DocumentNumber == document {
+            return &contacts[i], nil
         }
+    }
 
-        if err != nil {
-            log.Printf("Worker %d: Error creating order: %v", id, err)
-            // Add a small delay before retrying
-            time.Sleep(time.Millisecond * 100)
+    c := NewContact(q.Rand, q.UID)
+    c.Name = fmt.Sprintf("Warmup Worker %d", id)
+    c.DocumentType, c.DocumentNumber = DocumentIDCard, document
+    return q.AddContact(c)
+}
+
+// adoptStrayOrders resolves a booking that matched several orders. They
+// all belong to the contact of this worker, so they are strays of its own
+// earlier attempts rather than bookings of other workers: the booking
+// adopts the first, and the others are claimed and counted as unpaid so
+// they neither stay candidates nor push the account past its targets.
+func adoptStrayOrders(ambiguous *AmbiguousOrderError, highSpeed bool) (*Order, error) {
+    var adopted *Order
+    for i := range ambiguous.Candidates {
+        o := &ambiguous.Candidates[i]
+        if !claimOrder(o.ID, ambiguous.Booking) {
+            continue
         }
+        if adopted == nil {
+            adopted = o
+            ambiguous.Booking.OrderID = o.ID
+            continue
+        }
+        log.Printf("Counting stray unpaid order %s", o.ID)
+        activeWarmup.countStray(highSpeed)
+    }
+
+    if adopted == nil {
+        return nil, ambiguous
     }
+    return adopted, nil
 }
 
-func createUnpaidOrder(q *Query) error {
-    start := "Shang Hai"
-    end := "Su Zhou"
-    tripIDs, tripDate, err := q.QueryHighSpeedTicket([2]string{start, end}, BaseDate)
+// expectOrderStatus waits until the booked order reached status, giving the
+// services a moment to process the last transition
+func expectOrderStatus(q *Query, b *Booking, status int) error {
+    var order *Order
+    var err error
+    for attempt := 0; attempt < 5; attempt++ {
+        order, err = q.GetOrder(b.OrderID, !b.HighSpeed)
+        if err == nil && order.Status == status {
+            return nil
+        }
+        time.Sleep(time.Millisecond * 100)
+    }
+
     if err != nil {
-        return fmt.Errorf("failed to query ticket: %v", err)
+        return err
     }
-    if len(tripIDs) == 0 {
-        return fmt.Errorf("no trips available")
+    return fmt.Errorf("order %s has status %d, expected %d", b.OrderID, order.Status, status)
+}
+
+func createUnpaidOrder(q *Query, highSpeed bool) error {
+    _, err := bookUnpaidOrder(q, highSpeed)
+    return err
+}
+
+// payNewOrder books an order and pays that very order
+func payNewOrder(q *Query, highSpeed bool) (*Booking, error) {
+    booking, err := bookUnpaidOrder(q, highSpeed)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create unpaid order: %v", err)
+    }
+
+    if err := q.PayOrder(booking.OrderID, booking.TripID); err != nil {
+        return nil, err
+    }
+    if err := expectOrderStatus(q, booking, OrderPaid); err != nil {
+        return nil, fmt.Errorf("payment not applied: %v", err)
     }
-    return q.Preserve(start, end, tripIDs, true, tripDate)
+
+    return booking, nil
 }
 
-func createPaidOrder(q *Query) error {
-    // First create unpaid order
-    if err := createUnpaidOrder(q); err != nil {
-        return fmt.Errorf("failed to create unpaid order: %v", err)
+func createPaidOrder(q *Query, highSpeed bool) error {
+    _, err := payNewOrder(q, highSpeed)
+    return err
+}
+
+// collectNewOrder books, pays and collects an order
+func collectNewOrder(q *Query, highSpeed bool) (*Booking, error) {
+    booking, err := payNewOrder(q, highSpeed)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create paid order: %v", err)
     }
-    
-    // Wait a short time for the order to be processed
-    time.Sleep(time.Millisecond * 100)
-    
-    // Query the created order and pay for it
-    pairs, err := q.QueryOrders([]int{0}, false)
+
+    if err := q.CollectTicket(booking.OrderID); err != nil {
+        return nil, err
+    }
+    if err := expectOrderStatus(q, booking, OrderCollected); err != nil {
+        return nil, fmt.Errorf("collection not applied: %v", err)
+    }
+
+    return booking, nil
+}
+
+func createCollectedOrder(q *Query, highSpeed bool) error {
+    _, err := collectNewOrder(q, highSpeed)
+    return err
+}
+
+func createExecutedOrder(q *Query, highSpeed bool) error {
+    booking, err := collectNewOrder(q, highSpeed)
     if err != nil {
-        return fmt.Errorf("failed to query orders: %v", err)
+        return fmt.Errorf("failed to create collected order: %v", err)
     }
-    if len(pairs) == 0 {
-        return fmt.Errorf("no unpaid orders found")
+
+    if err := q.EnterStation(booking.OrderID); err != nil {
+        return err
     }
-    
-    orderID, tripID := pairs[0][0], pairs[0][1]
-    return q.PayOrder(orderID, tripID)
+    if err := expectOrderStatus(q, booking, OrderUsed); err != nil {
+        return fmt.Errorf("station entry not applied: %v", err)
+    }
+
+    return nil
 }
 
-func createCollectedOrder(q *Query) error {
-    // First create paid order
-    if err := createPaidOrder(q); err != nil {
+func createConsignedOrder(q *Query, highSpeed bool) error {
+    booking, err := payNewOrder(q, highSpeed)
+    if err != nil {
         return fmt.Errorf("failed to create paid order: %v", err)
     }
-    
-    // Wait a short time for the payment to be processed
-    time.Sleep(time.Millisecond * 200)
-    
-    // Query the paid order and collect it
-    pairs, err := q.QueryOrders([]int{1}, false)
+
+    err = q.PutConsign(map[string]interface{}{
+        "accountId": q.UID,
+        "orderId":   booking.OrderID,
+        "from":      booking.From,
+        "to":        booking.To,
+    })
     if err != nil {
-        return fmt.Errorf("failed to query orders: %v", err)
+        return err
     }
-    if len(pairs) == 0 {
-        return fmt.Errorf("no paid orders found")
+
+    consign, err := q.QueryConsignByOrder(booking.OrderID)
+    if err != nil {
+        return err
     }
-    
-    return q.CollectTicket(pairs[0][0])
+    if consign == nil {
+        return fmt.Errorf("consignment of order %s not stored", booking.OrderID)
+    }
+
+    return nil
 }
 
-func createConsignedOrder(q *Query) error {
-    // First create paid order
-    if err := createPaidOrder(q); err != nil {
-        return fmt.Errorf("failed to create paid order: %v", err)
-    }
-    
-    // Wait a short time for the payment to be processed
-    time.Sleep(time.Millisecond * 200)
-    
-    // Query order info and consign it
-    ordersList, err := q.QueryOrdersAllInfo(false)
+func createCancelledOrder(q *Query, highSpeed bool) error {
+    booking, err := bookUnpaidOrder(q, highSpeed)
     if err != nil {
-        return fmt.Errorf("failed to query orders: %v", err)
+        return fmt.Errorf("failed to create unpaid order: %v", err)
     }
-    if len(ordersList) == 0 {
-        return fmt.Errorf("no orders found for consignment")
+
+    if err := q.CancelOrder(booking.OrderID, q.UID); err != nil {
+        return err
     }
-    
-    return q.PutConsign(ordersList[0])
-}
\ No newline at end of file
+    if err := expectOrderStatus(q, booking, OrderCancel); err != nil {
+        return fmt.Errorf("cancellation not applied: %v", err)
+    }
+
+    return nil
+}